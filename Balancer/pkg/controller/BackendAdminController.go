@@ -0,0 +1,230 @@
+package controller
+
+import (
+	"LoadBalancer/Balancer/pkg/service"
+	"LoadBalancer/Balancer/pkg/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type BackendAdminController interface {
+	ListBackends(w http.ResponseWriter, r *http.Request)
+	AddBackend(w http.ResponseWriter, r *http.Request)
+	UpdateBackend(w http.ResponseWriter, r *http.Request)
+	RemoveBackend(w http.ResponseWriter, r *http.Request)
+	GetBackend(w http.ResponseWriter, r *http.Request)
+	DrainBackend(w http.ResponseWriter, r *http.Request)
+}
+
+const defaultDrainTimeout = 30 * time.Second
+
+// drainResponseMargin is left for writing the response after a drain that
+// was waited for.
+const drainResponseMargin = 5 * time.Second
+
+type BackendAdminImpl struct {
+	service      service.LoadBlancerService
+	LBcontroller LoadBalancerController
+}
+
+type backendRequest struct {
+	URL     string `json:"url"`
+	Weight  *int   `json:"weight"`
+	Enabled *bool  `json:"enabled"`
+	Resume  bool   `json:"resume"`
+}
+
+func NewBackendAdminController(service service.LoadBlancerService, LBcontroller LoadBalancerController) *BackendAdminImpl {
+	return &BackendAdminImpl{
+		service:      service,
+		LBcontroller: LBcontroller,
+	}
+}
+
+func (con *BackendAdminImpl) ListBackends(w http.ResponseWriter, r *http.Request) {
+	backends := con.service.GetBackends()
+	infos := make([]service.BackendInfo, 0, len(backends))
+	for _, b := range backends {
+		infos = append(infos, b.Info())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(infos); err != nil {
+		log.Printf("ListBackends: Error encoding response: %v", err)
+	}
+}
+
+func (con *BackendAdminImpl) GetBackend(w http.ResponseWriter, r *http.Request) {
+	host := mux.Vars(r)["host"]
+	backend := con.service.FindBackend(host)
+	if backend == nil {
+		http.Error(w, fmt.Sprintf("backend %s not found", host), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(backend.Info()); err != nil {
+		log.Printf("GetBackend: Error encoding response: %v", err)
+	}
+}
+
+func (con *BackendAdminImpl) AddBackend(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("AddBackend: Request received at %s", startTime.Format(time.RFC3339))
+
+	var req backendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("AddBackend: Error decoding request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	backendUrl, weight, err := utils.ParseBackend(req.URL)
+	if err != nil {
+		log.Printf("AddBackend: Invalid backend URL %q: %v", req.URL, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Weight != nil {
+		if *req.Weight < 1 {
+			http.Error(w, "weight must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		weight = *req.Weight
+	}
+
+	backend := con.LBcontroller.NewBackend(backendUrl, weight)
+	if req.Enabled != nil {
+		backend.SetEnabled(*req.Enabled)
+	}
+	if err := con.LBcontroller.AddNewBackend(backend); err != nil {
+		log.Printf("AddBackend: Error adding backend: %v", err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrBackendExists) {
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, "Backend added successfully")
+
+	log.Printf("AddBackend: Backend %s added successfully, status code: %d, duration: %v", backendUrl.Host, http.StatusCreated, time.Since(startTime))
+}
+
+func (con *BackendAdminImpl) UpdateBackend(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("UpdateBackend: Request received at %s", startTime.Format(time.RFC3339))
+
+	host := mux.Vars(r)["host"]
+	backend := con.service.FindBackend(host)
+	if backend == nil {
+		log.Printf("UpdateBackend: Backend %s not found", host)
+		http.Error(w, fmt.Sprintf("backend %s not found", host), http.StatusNotFound)
+		return
+	}
+
+	var req backendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("UpdateBackend: Error decoding request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Weight != nil {
+		if err := con.service.SetBackendWeight(host, *req.Weight); err != nil {
+			log.Printf("UpdateBackend: Error changing weight of %s: %v", host, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Enabled != nil {
+		backend.SetEnabled(*req.Enabled)
+	}
+	if req.Resume {
+		backend.Resume()
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "Backend updated successfully")
+
+	log.Printf("UpdateBackend: Backend %s updated successfully, status code: %d, duration: %v", host, http.StatusOK, time.Since(startTime))
+}
+
+func (con *BackendAdminImpl) RemoveBackend(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("RemoveBackend: Request received at %s", startTime.Format(time.RFC3339))
+
+	host := mux.Vars(r)["host"]
+	if err := con.service.RemoveBackend(host); err != nil {
+		log.Printf("RemoveBackend: Error removing backend: %v", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "Backend removed successfully")
+
+	log.Printf("RemoveBackend: Backend %s removed successfully, status code: %d, duration: %v", host, http.StatusOK, time.Since(startTime))
+}
+
+// DrainBackend stops routing new requests to the backend. With wait=true the
+// response is delayed until the backend has drained or the timeout passed,
+// otherwise the drain continues in the background and its progress is
+// visible through GetBackend.
+func (con *BackendAdminImpl) DrainBackend(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("DrainBackend: Request received at %s", startTime.Format(time.RFC3339))
+
+	host := mux.Vars(r)["host"]
+	timeout := defaultDrainTimeout
+	if raw := r.URL.Query().Get("timeout"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 {
+			http.Error(w, fmt.Sprintf("invalid timeout %q", raw), http.StatusBadRequest)
+			return
+		}
+		timeout = parsed
+	}
+
+	done, err := con.service.DrainBackend(host, timeout)
+	if err != nil {
+		log.Printf("DrainBackend: Error draining backend: %v", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	backend := con.service.FindBackend(host)
+
+	status := http.StatusAccepted
+	if r.URL.Query().Get("wait") == "true" {
+		// The drain may outlast the server's write timeout, which would
+		// drop the response.
+		deadline := time.Now().Add(timeout + drainResponseMargin)
+		if err := http.NewResponseController(w).SetWriteDeadline(deadline); err != nil {
+			log.Printf("DrainBackend: Error extending write deadline: %v", err)
+		}
+		select {
+		case <-done:
+			status = http.StatusOK
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if backend != nil {
+		if err := json.NewEncoder(w).Encode(backend.Info()); err != nil {
+			log.Printf("DrainBackend: Error encoding response: %v", err)
+		}
+	}
+
+	log.Printf("DrainBackend: Drain of %s requested, status code: %d, duration: %v", host, status, time.Since(startTime))
+}
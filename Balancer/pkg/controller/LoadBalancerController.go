@@ -1,18 +1,23 @@
 package controller
 
 import (
+	"LoadBalancer/Balancer/pkg/metrics"
 	"LoadBalancer/Balancer/pkg/service"
 	"LoadBalancer/Balancer/pkg/utils"
 	"context"
 	"log"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
 	"sync"
 	"time"
 )
 
 type LoadBalancerController interface {
 	BalanceRequest(w http.ResponseWriter, r *http.Request)
-	AddNewBackend(backend *service.Backend)
+	AddNewBackend(backend *service.Backend) error
+	NewBackend(backendUrl *url.URL, weight int) *service.Backend
 }
 
 type LoadBalancerImpl struct {
@@ -25,8 +30,48 @@ func NewLoadBlancerController(service service.LoadBlancerService) LoadBalancerCo
 	}
 }
 
-func (lb *LoadBalancerImpl) AddNewBackend(backend *service.Backend) {
-	lb.service.AddBackend(backend)
+func (lb *LoadBalancerImpl) AddNewBackend(backend *service.Backend) error {
+	return lb.service.AddBackend(backend)
+}
+
+func (lb *LoadBalancerImpl) NewBackend(backendUrl *url.URL, weight int) *service.Backend {
+	proxy := httputil.NewSingleHostReverseProxy(backendUrl)
+	backend := &service.Backend{
+		URL:          backendUrl,
+		Alive:        true,
+		ReverseProxy: proxy,
+		Weight:       weight,
+	}
+
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		lb.service.ReportResponse(backend, resp.StatusCode)
+		observeResponse(backendUrl.Host, resp.StatusCode, resp.Request)
+		return nil
+	}
+	proxy.ErrorHandler = func(writer http.ResponseWriter, request *http.Request, e error) {
+		log.Printf("[%s] %s\n", backendUrl.Host, e.Error())
+		lb.service.ReportResponse(backend, http.StatusBadGateway)
+		observeResponse(backendUrl.Host, http.StatusBadGateway, request)
+		retries := utils.GetRetryFromContext(request)
+		if retries < 3 {
+			metrics.ProxyRetries.Inc(backendUrl.Host, "retry")
+			select {
+			case <-time.After(10 * time.Millisecond):
+				ctx := context.WithValue(request.Context(), utils.Retry, retries+1)
+				proxy.ServeHTTP(writer, request.WithContext(ctx))
+			}
+			return
+		}
+
+		lb.service.MarkBackendStatus(backendUrl, false)
+		metrics.ProxyRetries.Inc(backendUrl.Host, "rebalance")
+
+		attempts := utils.GetAttemptsFromContext(request)
+		log.Printf("%s(%s) Attempting retry %d\n", request.RemoteAddr, request.URL.Path, attempts)
+		ctx := context.WithValue(request.Context(), utils.Attempts, attempts+1)
+		lb.BalanceRequest(writer, request.WithContext(ctx))
+	}
+	return backend
 }
 
 func (lb LoadBalancerImpl) BalanceRequest(w http.ResponseWriter, r *http.Request) {
@@ -37,7 +82,7 @@ func (lb LoadBalancerImpl) BalanceRequest(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	peer := lb.service.GetNextServer()
+	peer := lb.service.GetNextServer(r)
 	if peer == nil {
 		http.Error(w, "Service not available", http.StatusServiceUnavailable)
 		return
@@ -45,6 +90,7 @@ func (lb LoadBalancerImpl) BalanceRequest(w http.ResponseWriter, r *http.Request
 
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
+	ctx = context.WithValue(ctx, utils.StartTime, time.Now())
 
 	r = r.WithContext(ctx)
 
@@ -53,9 +99,13 @@ func (lb LoadBalancerImpl) BalanceRequest(w http.ResponseWriter, r *http.Request
 
 	errChan := make(chan error, 1)
 
+	peer.IncInFlight()
 	go func() {
 		defer wg.Done()
+		defer peer.DecInFlight()
+		start := time.Now()
 		peer.ReverseProxy.ServeHTTP(w, r)
+		peer.ObserveLatency(time.Since(start))
 	}()
 
 	go func() {
@@ -73,3 +123,9 @@ func (lb LoadBalancerImpl) BalanceRequest(w http.ResponseWriter, r *http.Request
 		}
 	}
 }
+
+func observeResponse(host string, status int, r *http.Request) {
+	code := strconv.Itoa(status)
+	metrics.BackendRequests.Inc(host, code)
+	metrics.BackendLatency.ObserveDuration(time.Since(utils.GetStartTimeFromContext(r)), host, code)
+}
@@ -2,32 +2,50 @@ package health
 
 import (
 	"LoadBalancer/Balancer/pkg/service"
+	"LoadBalancer/Balancer/pkg/utils"
 	"log"
+	"sync"
 	"time"
 )
 
 type HealthChecker interface {
 	HealthCheck()
+	Stop()
 }
 
 type HealthCheckerImpl struct {
-	service service.LoadBlancerService
+	service  service.LoadBlancerService
+	config   utils.HealthCheckConfig
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
-func NewLHealthChecker(service service.LoadBlancerService) HealthChecker {
+func NewLHealthChecker(service service.LoadBlancerService, config utils.HealthCheckConfig) HealthChecker {
 	return &HealthCheckerImpl{
 		service: service,
+		config:  config,
+		stop:    make(chan struct{}),
 	}
 }
 
 func (ch *HealthCheckerImpl) HealthCheck() {
-	t := time.NewTicker(time.Minute * 2)
+	t := time.NewTicker(ch.config.Interval)
+	defer t.Stop()
 	for {
 		select {
 		case <-t.C:
 			log.Println("Starting health check...")
-			ch.service.HealthCheck()
+			ch.service.HealthCheck(ch.config)
 			log.Println("Health check completed")
+		case <-ch.stop:
+			log.Println("Health checker stopped")
+			return
 		}
 	}
 }
+
+func (ch *HealthCheckerImpl) Stop() {
+	ch.stopOnce.Do(func() {
+		close(ch.stop)
+	})
+}
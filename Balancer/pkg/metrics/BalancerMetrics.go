@@ -0,0 +1,50 @@
+package metrics
+
+import "sync/atomic"
+
+var (
+	BackendRequests = NewCounterVec(
+		"lb_backend_requests_total",
+		"Proxied requests by backend and response status code.",
+		"backend", "code",
+	)
+	BackendLatency = NewHistogramVec(
+		"lb_backend_request_duration_seconds",
+		"Latency of proxied requests by backend and response status code.",
+		DefaultBuckets,
+		"backend", "code",
+	)
+	ProxyRetries = NewCounterVec(
+		"lb_proxy_retries_total",
+		"Retries from the proxy error handler: same-backend retries and rebalanced attempts.",
+		"backend", "kind",
+	)
+	// RateLimitDecisions counts decisions by tier: "client" for known
+	// clients, "anonymous" and "unknown" for the others. Per-client counts
+	// are kept by clientDecisions only when enabled.
+	RateLimitDecisions = NewCounterVec(
+		"ratelimit_decisions_total",
+		"Rate limiter decisions by client tier and result.",
+		"tier", "decision",
+	)
+)
+
+// clientDecisions counts the decisions of every known client. It adds a
+// series per client, so it is only registered by EnableClientDecisions.
+var clientDecisions atomic.Pointer[CounterVec]
+
+func EnableClientDecisions() {
+	clientDecisions.Store(NewCounterVec(
+		"ratelimit_client_decisions_total",
+		"Rate limiter decisions of known clients by client and result.",
+		"client_id", "decision",
+	))
+}
+
+// RecordClientDecision counts a decision about a known client.
+func RecordClientDecision(clientID string, decision string) {
+	RateLimitDecisions.Inc("client", decision)
+	if c := clientDecisions.Load(); c != nil {
+		c.Inc(clientID, decision)
+	}
+}
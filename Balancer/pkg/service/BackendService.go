@@ -1,14 +1,58 @@
 package service
 
 import (
+	"math"
 	"net/http/httputil"
 	"net/url"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// ewmaDecay is the weight given to the newest latency sample.
+const ewmaDecay = 0.3
+
+// ewmaIdleDecay is the time constant with which a backend's latency loses
+// weight while no samples arrive, so one slow period is forgotten.
+const ewmaIdleDecay = 10 * time.Second
+
+const (
+	DrainNone int32 = iota
+	Draining
+	Drained
+	DrainTimedOut
+)
+
+var drainStateNames = map[int32]string{
+	DrainNone:     "",
+	Draining:      "draining",
+	Drained:       "drained",
+	DrainTimedOut: "drain-timeout",
+}
+
+const drainPollInterval = 100 * time.Millisecond
+
 type BackendService interface {
 	SetAlive(alive bool)
 	IsAlive() (alive bool)
+	IncInFlight()
+	DecInFlight()
+	InFlight() int64
+	GetWeight() int
+	SetWeight(weight int)
+	SetEnabled(enabled bool)
+	IsEnabled() bool
+	Info() BackendInfo
+	ObserveLatency(d time.Duration)
+	Latency() time.Duration
+	Score(baseline time.Duration) float64
+	RecordProbe(ok bool, rise int, fall int) (alive bool, changed bool)
+	Eject(until time.Time)
+	IsEjected() bool
+	IsAvailable() bool
+	Drain(timeout time.Duration) <-chan int32
+	Resume()
+	DrainState() int32
 }
 
 type Backend struct {
@@ -16,6 +60,28 @@ type Backend struct {
 	Alive        bool
 	mux          sync.RWMutex
 	ReverseProxy *httputil.ReverseProxy
+	Weight       int
+	Disabled     bool
+	inFlight     int64
+	ewmaLatency  float64
+	lastSample   time.Time
+
+	// Consecutive health probe results used for rise/fall thresholds.
+	probeSuccesses int
+	probeFailures  int
+
+	// ejectedUntil is a unix nano timestamp set by the OutlierDetector.
+	ejectedUntil int64
+	outlier      outlierStats
+
+	// drainState holds one of the Drain* constants, drainGen invalidates
+	// the watcher of a previous drain when the backend is resumed.
+	drainState int32
+	drainGen   int64
+
+	// currentWeight is the smooth weighted round-robin state, guarded by
+	// the owning WeightedRoundRobinPool.
+	currentWeight int
 }
 
 func (b *Backend) SetAlive(alive bool) {
@@ -30,3 +96,197 @@ func (b *Backend) IsAlive() (alive bool) {
 	b.mux.RUnlock()
 	return
 }
+
+func (b *Backend) IncInFlight() {
+	atomic.AddInt64(&b.inFlight, 1)
+}
+
+func (b *Backend) DecInFlight() {
+	atomic.AddInt64(&b.inFlight, -1)
+}
+
+func (b *Backend) InFlight() int64 {
+	return atomic.LoadInt64(&b.inFlight)
+}
+
+func (b *Backend) GetWeight() int {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	if b.Weight < 1 {
+		return 1
+	}
+	return b.Weight
+}
+
+func (b *Backend) SetWeight(weight int) {
+	b.mux.Lock()
+	b.Weight = weight
+	b.mux.Unlock()
+}
+
+func (b *Backend) SetEnabled(enabled bool) {
+	b.mux.Lock()
+	b.Disabled = !enabled
+	b.mux.Unlock()
+}
+
+func (b *Backend) IsEnabled() bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return !b.Disabled
+}
+
+// ObserveLatency folds a sample into the smoothed latency. The longer the
+// backend went without samples, the less the previous value counts.
+func (b *Backend) ObserveLatency(d time.Duration) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	now := time.Now()
+	if b.ewmaLatency == 0 {
+		b.ewmaLatency = float64(d)
+	} else {
+		keep := (1 - ewmaDecay) * idleWeight(now.Sub(b.lastSample))
+		b.ewmaLatency = keep*b.ewmaLatency + (1-keep)*float64(d)
+	}
+	b.lastSample = now
+}
+
+// idleWeight is the weight left to a latency sampled idle ago.
+func idleWeight(idle time.Duration) float64 {
+	return math.Exp(-float64(idle) / float64(ewmaIdleDecay))
+}
+
+func (b *Backend) Latency() time.Duration {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return time.Duration(b.ewmaLatency)
+}
+
+// Score estimates how long a new request would wait on this backend: the
+// smoothed latency multiplied by the requests already queued on it.
+// baseline is the typical latency of the pool. A backend without samples
+// yet is assumed to have it, and an idle backend's latency drifts towards
+// it, so neither wins nor loses every pick on stale data. Without any
+// latency known, backends score by in-flight count alone.
+func (b *Backend) Score(baseline time.Duration) float64 {
+	b.mux.RLock()
+	latency, last := b.ewmaLatency, b.lastSample
+	b.mux.RUnlock()
+
+	if latency == 0 {
+		latency = float64(baseline)
+	} else if baseline > 0 {
+		w := idleWeight(time.Since(last))
+		latency = w*latency + (1-w)*float64(baseline)
+	}
+	if latency == 0 {
+		latency = 1
+	}
+	return latency * float64(b.InFlight()+1)
+}
+
+// RecordProbe registers a health probe result and flips the backend state
+// only after rise consecutive successes or fall consecutive failures.
+func (b *Backend) RecordProbe(ok bool, rise int, fall int) (alive bool, changed bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if ok {
+		b.probeSuccesses++
+		b.probeFailures = 0
+		if !b.Alive && b.probeSuccesses >= rise {
+			b.Alive = true
+			changed = true
+		}
+	} else {
+		b.probeFailures++
+		b.probeSuccesses = 0
+		if b.Alive && b.probeFailures >= fall {
+			b.Alive = false
+			changed = true
+		}
+	}
+	return b.Alive, changed
+}
+
+func (b *Backend) Eject(until time.Time) {
+	atomic.StoreInt64(&b.ejectedUntil, until.UnixNano())
+}
+
+func (b *Backend) IsEjected() bool {
+	return time.Now().UnixNano() < atomic.LoadInt64(&b.ejectedUntil)
+}
+
+func (b *Backend) IsAvailable() bool {
+	return b.DrainState() == DrainNone && b.IsEnabled() && b.IsAlive() && !b.IsEjected()
+}
+
+func (b *Backend) DrainState() int32 {
+	return atomic.LoadInt32(&b.drainState)
+}
+
+// Drain stops new requests from being routed to the backend and waits for
+// the in-flight ones to finish. The returned channel receives Drained or
+// DrainTimedOut once, or is closed without a value if the backend is resumed.
+func (b *Backend) Drain(timeout time.Duration) <-chan int32 {
+	gen := atomic.AddInt64(&b.drainGen, 1)
+	atomic.StoreInt32(&b.drainState, Draining)
+	done := make(chan int32, 1)
+
+	go func() {
+		defer close(done)
+		deadline := time.Now().Add(timeout)
+		t := time.NewTicker(drainPollInterval)
+		defer t.Stop()
+		for {
+			if atomic.LoadInt64(&b.drainGen) != gen {
+				return
+			}
+			result := Draining
+			if b.InFlight() == 0 {
+				result = Drained
+			} else if time.Now().After(deadline) {
+				result = DrainTimedOut
+			}
+			if result != Draining {
+				if atomic.CompareAndSwapInt32(&b.drainState, Draining, result) && atomic.LoadInt64(&b.drainGen) == gen {
+					done <- result
+				}
+				return
+			}
+			<-t.C
+		}
+	}()
+	return done
+}
+
+func (b *Backend) Resume() {
+	atomic.AddInt64(&b.drainGen, 1)
+	atomic.StoreInt32(&b.drainState, DrainNone)
+}
+
+type BackendInfo struct {
+	URL       string  `json:"url"`
+	Host      string  `json:"host"`
+	Alive     bool    `json:"alive"`
+	Enabled   bool    `json:"enabled"`
+	Ejected   bool    `json:"ejected"`
+	Drain     string  `json:"drain,omitempty"`
+	Weight    int     `json:"weight"`
+	InFlight  int64   `json:"in_flight"`
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+func (b *Backend) Info() BackendInfo {
+	return BackendInfo{
+		URL:       b.URL.String(),
+		Host:      b.URL.Host,
+		Alive:     b.IsAlive(),
+		Enabled:   b.IsEnabled(),
+		Ejected:   b.IsEjected(),
+		Drain:     drainStateNames[b.DrainState()],
+		Weight:    b.GetWeight(),
+		InFlight:  b.InFlight(),
+		LatencyMs: float64(b.Latency()) / float64(time.Millisecond),
+	}
+}
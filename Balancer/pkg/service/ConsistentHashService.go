@@ -0,0 +1,130 @@
+package service
+
+import (
+	"fmt"
+	"hash/crc32"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultVirtualNodes = 100
+
+type KeyFunc func(r *http.Request) string
+
+// ParseHashKey turns a key definition such as "query:client_id",
+// "header:X-User-ID", "cookie:session" or "ip" into a KeyFunc.
+func ParseHashKey(def string) (KeyFunc, error) {
+	if def == "" {
+		def = "query:client_id"
+	}
+	if def == "ip" {
+		return remoteIP, nil
+	}
+
+	source, name, found := strings.Cut(def, ":")
+	if !found || name == "" {
+		return nil, fmt.Errorf("invalid hash key %q", def)
+	}
+	switch source {
+	case "query":
+		return func(r *http.Request) string { return r.URL.Query().Get(name) }, nil
+	case "header":
+		return func(r *http.Request) string { return r.Header.Get(name) }, nil
+	case "cookie":
+		return func(r *http.Request) string {
+			c, err := r.Cookie(name)
+			if err != nil {
+				return ""
+			}
+			return c.Value
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown hash key source %q", source)
+	}
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type ringNode struct {
+	hash    uint32
+	backend *Backend
+}
+
+// ConsistentHashPool maps a request key onto a hash ring with virtual nodes.
+// Dead backends stay on the ring and are skipped while walking clockwise, so
+// only the keys owned by a failed backend move elsewhere.
+type ConsistentHashPool struct {
+	ServerPool
+	keyFunc      KeyFunc
+	virtualNodes int
+	ringMutex    sync.RWMutex
+	ring         []ringNode
+}
+
+func NewConsistentHashPool(keyFunc KeyFunc, virtualNodes int) *ConsistentHashPool {
+	if virtualNodes < 1 {
+		virtualNodes = defaultVirtualNodes
+	}
+	pool := &ConsistentHashPool{
+		keyFunc:      keyFunc,
+		virtualNodes: virtualNodes,
+	}
+	pool.onChange = pool.rebuildRing
+	return pool
+}
+
+func (s *ConsistentHashPool) rebuildRing() {
+	s.ringMutex.Lock()
+	defer s.ringMutex.Unlock()
+
+	backends := s.GetBackends()
+	ring := make([]ringNode, 0, len(backends)*s.virtualNodes)
+	for _, b := range backends {
+		id := b.URL.String()
+		for i := 0; i < s.virtualNodes*b.GetWeight(); i++ {
+			ring = append(ring, ringNode{
+				hash:    crc32.ChecksumIEEE([]byte(id + "#" + strconv.Itoa(i))),
+				backend: b,
+			})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	s.ring = ring
+}
+
+func (s *ConsistentHashPool) GetNextServer(r *http.Request) *Backend {
+	key := ""
+	if r != nil {
+		key = s.keyFunc(r)
+	}
+	if key == "" {
+		// Requests without a key cannot have affinity, fall back to round-robin.
+		return s.ServerPool.GetNextServer(r)
+	}
+
+	s.ringMutex.RLock()
+	defer s.ringMutex.RUnlock()
+	if len(s.ring) == 0 {
+		return nil
+	}
+
+	hash := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(s.ring), func(i int) bool { return s.ring[i].hash >= hash })
+	for i := 0; i < len(s.ring); i++ {
+		node := s.ring[(start+i)%len(s.ring)]
+		if node.backend.IsAvailable() {
+			return node.backend
+		}
+	}
+	return nil
+}
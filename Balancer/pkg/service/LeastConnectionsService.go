@@ -0,0 +1,31 @@
+package service
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+type LeastConnectionsPool struct {
+	ServerPool
+}
+
+func (s *LeastConnectionsPool) GetNextServer(r *http.Request) *Backend {
+	backends := s.GetBackends()
+	if len(backends) == 0 {
+		return nil
+	}
+
+	// Start from a rotating offset so that ties are spread across backends.
+	next := int(atomic.AddUint64(&s.current, uint64(1)) % uint64(len(backends)))
+	var best *Backend
+	for i := 0; i < len(backends); i++ {
+		b := backends[(next+i)%len(backends)]
+		if !b.IsAvailable() {
+			continue
+		}
+		if best == nil || b.InFlight() < best.InFlight() {
+			best = b
+		}
+	}
+	return best
+}
@@ -2,34 +2,208 @@ package service
 
 import (
 	"LoadBalancer/Balancer/pkg/utils"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type LoadBlancerService interface {
 	NextIndex() int
-	GetNextServer() *Backend
-	HealthCheck()
-	AddBackend(backend *Backend)
+	GetNextServer(r *http.Request) *Backend
+	HealthCheck(cfg utils.HealthCheckConfig)
+	AddBackend(backend *Backend) error
+	GetBackends() []*Backend
+	FindBackend(host string) *Backend
+	RemoveBackend(host string) error
+	SetBackendWeight(host string, weight int) error
+	DrainBackend(host string, timeout time.Duration) (<-chan int32, error)
+	MarkBackendStatus(backendUrl *url.URL, alive bool)
+	ReportResponse(backend *Backend, status int)
 }
 
+const (
+	RoundRobin         = "round-robin"
+	WeightedRoundRobin = "weighted-round-robin"
+	LeastConnections   = "least-connections"
+	ConsistentHash     = "consistent-hash"
+	PowerOfTwo         = "p2c-ewma"
+)
+
+// ErrBackendExists is returned when a backend with the same host is already
+// in the pool.
+var ErrBackendExists = errors.New("backend already exists")
+
+type PoolConfig struct {
+	Strategy     string
+	HashKey      string
+	VirtualNodes int
+	Outlier      OutlierConfig
+}
+
+func NewLoadBalancerService(cfg PoolConfig) (LoadBlancerService, error) {
+	var pool interface {
+		LoadBlancerService
+		base() *ServerPool
+	}
+	switch cfg.Strategy {
+	case "", RoundRobin:
+		pool = &ServerPool{}
+	case WeightedRoundRobin:
+		pool = &WeightedRoundRobinPool{}
+	case LeastConnections:
+		pool = &LeastConnectionsPool{}
+	case PowerOfTwo:
+		pool = &PowerOfTwoPool{}
+	case ConsistentHash:
+		keyFunc, err := ParseHashKey(cfg.HashKey)
+		if err != nil {
+			return nil, err
+		}
+		pool = NewConsistentHashPool(keyFunc, cfg.VirtualNodes)
+	default:
+		return nil, fmt.Errorf("unknown balancing strategy %q", cfg.Strategy)
+	}
+
+	if cfg.Outlier.Enabled() {
+		base := pool.base()
+		base.outlier = NewOutlierDetector(cfg.Outlier, base)
+	}
+	return pool, nil
+}
+
+// ServerPool keeps the backends in a copy-on-write slice: writers replace the
+// slice under mutex, so readers can iterate over a snapshot without holding
+// any lock while a request is being routed.
 type ServerPool struct {
+	mutex    sync.RWMutex
 	backends []*Backend
 	current  uint64
+	outlier  *OutlierDetector
+	onChange func()
+}
+
+func (s *ServerPool) base() *ServerPool {
+	return s
+}
+
+func (s *ServerPool) GetBackends() []*Backend {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.backends
+}
+
+func (s *ServerPool) FindBackend(host string) *Backend {
+	for _, b := range s.GetBackends() {
+		if b.URL.Host == host {
+			return b
+		}
+	}
+	return nil
+}
+
+// AddBackend adds the backend unless one with the same host exists. The
+// check is made under the pool mutex, so concurrent adds of one host cannot
+// both succeed.
+func (s *ServerPool) AddBackend(backend *Backend) error {
+	s.mutex.Lock()
+	for _, b := range s.backends {
+		if b.URL.Host == backend.URL.Host {
+			s.mutex.Unlock()
+			return fmt.Errorf("backend %s: %w", backend.URL.Host, ErrBackendExists)
+		}
+	}
+	backends := make([]*Backend, 0, len(s.backends)+1)
+	backends = append(backends, s.backends...)
+	s.backends = append(backends, backend)
+	s.mutex.Unlock()
+	s.changed()
+	return nil
 }
 
-func (s *ServerPool) AddBackend(backend *Backend) {
-	s.backends = append(s.backends, backend)
+func (s *ServerPool) RemoveBackend(host string) error {
+	s.mutex.Lock()
+	backends := make([]*Backend, 0, len(s.backends))
+	for _, b := range s.backends {
+		if b.URL.Host != host {
+			backends = append(backends, b)
+		}
+	}
+	if len(backends) == len(s.backends) {
+		s.mutex.Unlock()
+		return fmt.Errorf("backend %s not found", host)
+	}
+	s.backends = backends
+	s.mutex.Unlock()
+	s.changed()
+	return nil
+}
+
+func (s *ServerPool) SetBackendWeight(host string, weight int) error {
+	if weight < 1 {
+		return fmt.Errorf("weight must be a positive integer")
+	}
+	b := s.FindBackend(host)
+	if b == nil {
+		return fmt.Errorf("backend %s not found", host)
+	}
+	b.SetWeight(weight)
+	s.changed()
+	return nil
+}
+
+func (s *ServerPool) DrainBackend(host string, timeout time.Duration) (<-chan int32, error) {
+	b := s.FindBackend(host)
+	if b == nil {
+		return nil, fmt.Errorf("backend %s not found", host)
+	}
+
+	log.Printf("%s draining, %d requests in flight\n", b.URL, b.InFlight())
+	done := b.Drain(timeout)
+	result := make(chan int32, 1)
+	go func() {
+		defer close(result)
+		state, ok := <-done
+		if !ok {
+			log.Printf("%s drain cancelled\n", b.URL)
+			return
+		}
+		if state == Drained {
+			log.Printf("%s drained\n", b.URL)
+		} else {
+			log.Printf("%s drain deadline passed with %d requests in flight\n", b.URL, b.InFlight())
+		}
+		result <- state
+	}()
+	return result, nil
+}
+
+func (s *ServerPool) changed() {
+	if s.onChange != nil {
+		s.onChange()
+	}
+}
+
+func (s *ServerPool) ReportResponse(backend *Backend, status int) {
+	if s.outlier != nil {
+		s.outlier.ReportResponse(backend, status)
+	}
 }
 
 func (s *ServerPool) NextIndex() int {
-	return int(atomic.AddUint64(&s.current, uint64(1)) % uint64(len(s.backends)))
+	n := len(s.GetBackends())
+	if n == 0 {
+		return 0
+	}
+	return int(atomic.AddUint64(&s.current, uint64(1)) % uint64(n))
 }
 
 func (s *ServerPool) MarkBackendStatus(backendUrl *url.URL, alive bool) {
-	for _, b := range s.backends {
+	for _, b := range s.GetBackends() {
 		if b.URL.String() == backendUrl.String() {
 			b.SetAlive(alive)
 			break
@@ -37,33 +211,40 @@ func (s *ServerPool) MarkBackendStatus(backendUrl *url.URL, alive bool) {
 	}
 }
 
-func (s *ServerPool) GetNextServer() *Backend {
-	next := s.NextIndex()
-	l := len(s.backends) + next
+func (s *ServerPool) GetNextServer(r *http.Request) *Backend {
+	backends := s.GetBackends()
+	if len(backends) == 0 {
+		return nil
+	}
+	next := int(atomic.AddUint64(&s.current, uint64(1)) % uint64(len(backends)))
+	l := len(backends) + next
 	for i := next; i < l; i++ {
-		idx := i % len(s.backends)
-		if s.backends[idx].IsAlive() {
+		idx := i % len(backends)
+		if backends[idx].IsAvailable() {
 			if i != next {
 				atomic.StoreUint64(&s.current, uint64(idx))
 			}
-			return s.backends[idx]
+			return backends[idx]
 		}
 	}
 	return nil
 }
 
-func (s *ServerPool) HealthCheck() {
+func (s *ServerPool) HealthCheck(cfg utils.HealthCheckConfig) {
 	var wg sync.WaitGroup
-	for _, b := range s.backends {
+	for _, b := range s.GetBackends() {
 		wg.Add(1)
 		go func(backend *Backend) {
 			defer wg.Done()
 			status := "up"
-			alive := utils.TryToConnect(backend.URL)
-			backend.SetAlive(alive)
+			alive, changed := backend.RecordProbe(utils.Probe(backend.URL, cfg), cfg.Rise, cfg.Fall)
 			if !alive {
 				status = "down"
 			}
+			if changed {
+				log.Printf("%s [%s] state changed\n", backend.URL, status)
+				return
+			}
 			log.Printf("%s [%s]\n", backend.URL, status)
 		}(b)
 	}
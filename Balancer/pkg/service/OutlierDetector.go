@@ -0,0 +1,145 @@
+package service
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+type OutlierConfig struct {
+	Consecutive5xx     int
+	ErrorRate          float64
+	Window             time.Duration
+	MinRequests        int
+	BaseEjection       time.Duration
+	MaxEjection        time.Duration
+	MaxEjectionPercent int
+}
+
+func (c OutlierConfig) Enabled() bool {
+	return c.Consecutive5xx > 0 || c.ErrorRate > 0
+}
+
+// outlierStats is the per-backend passive detection state, guarded by the
+// OutlierDetector mutex.
+type outlierStats struct {
+	consecutive5xx int
+	windowStart    time.Time
+	windowTotal    int
+	windowErrors   int
+	ejections      int
+}
+
+// OutlierDetector watches real proxied responses and temporarily ejects
+// backends that keep failing. Each new ejection of the same backend lasts
+// twice as long as the previous one, up to MaxEjection.
+type OutlierDetector struct {
+	config OutlierConfig
+	pool   *ServerPool
+	mu     sync.Mutex
+}
+
+func NewOutlierDetector(config OutlierConfig, pool *ServerPool) *OutlierDetector {
+	if config.Window <= 0 {
+		config.Window = 10 * time.Second
+	}
+	if config.BaseEjection <= 0 {
+		config.BaseEjection = 30 * time.Second
+	}
+	if config.MaxEjection < config.BaseEjection {
+		config.MaxEjection = 10 * config.BaseEjection
+	}
+	if config.MaxEjectionPercent <= 0 || config.MaxEjectionPercent > 100 {
+		config.MaxEjectionPercent = 50
+	}
+	return &OutlierDetector{
+		config: config,
+		pool:   pool,
+	}
+}
+
+func (d *OutlierDetector) ReportResponse(backend *Backend, status int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	stats := &backend.outlier
+	if now.Sub(stats.windowStart) > d.config.Window {
+		if stats.windowTotal > 0 && !backend.IsEjected() && stats.ejections > 0 {
+			// A clean window after an ejection slowly restores trust.
+			stats.ejections--
+		}
+		stats.windowStart = now
+		stats.windowTotal = 0
+		stats.windowErrors = 0
+	}
+
+	stats.windowTotal++
+	if status >= 500 {
+		stats.consecutive5xx++
+		stats.windowErrors++
+	} else {
+		stats.consecutive5xx = 0
+	}
+
+	if backend.IsEjected() {
+		return
+	}
+
+	reason := ""
+	if d.config.Consecutive5xx > 0 && stats.consecutive5xx >= d.config.Consecutive5xx {
+		reason = "consecutive 5xx"
+	} else if d.config.ErrorRate > 0 && stats.windowTotal >= d.config.MinRequests &&
+		float64(stats.windowErrors)/float64(stats.windowTotal) >= d.config.ErrorRate {
+		reason = "error rate"
+	}
+	if reason == "" {
+		return
+	}
+
+	if !d.canEject() {
+		log.Printf("%s outlier (%s) but max ejection percent reached\n", backend.URL, reason)
+		return
+	}
+
+	duration := d.ejectionDuration(stats.ejections)
+	backend.Eject(now.Add(duration))
+	if duration < d.config.MaxEjection {
+		// Past the cap another doubling would only grow the count that
+		// clean windows have to work off.
+		stats.ejections++
+	}
+	stats.consecutive5xx = 0
+	stats.windowStart = now
+	stats.windowTotal = 0
+	stats.windowErrors = 0
+	log.Printf("%s ejected for %v (%s)\n", backend.URL, duration, reason)
+}
+
+// ejectionDuration doubles BaseEjection for each previous ejection, capped
+// at MaxEjection. It stops doubling at the cap, so a large count cannot
+// overflow into a short duration.
+func (d *OutlierDetector) ejectionDuration(ejections int) time.Duration {
+	duration := d.config.BaseEjection
+	for i := 0; i < ejections; i++ {
+		if duration >= d.config.MaxEjection/2 {
+			return d.config.MaxEjection
+		}
+		duration *= 2
+	}
+	return min(duration, d.config.MaxEjection)
+}
+
+func (d *OutlierDetector) canEject() bool {
+	backends := d.pool.GetBackends()
+	if len(backends) == 0 {
+		return false
+	}
+	ejected := 0
+	for _, b := range backends {
+		if b.IsEjected() {
+			ejected++
+		}
+	}
+	return (ejected+1)*100 <= d.config.MaxEjectionPercent*len(backends)
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestOutlierEjectionDuration(t *testing.T) {
+	tests := []struct {
+		name      string
+		base      time.Duration
+		max       time.Duration
+		ejections int
+		want      time.Duration
+	}{
+		{"first ejection", 30 * time.Second, 5 * time.Minute, 0, 30 * time.Second},
+		{"doubles", 30 * time.Second, 5 * time.Minute, 2, 2 * time.Minute},
+		{"capped", 30 * time.Second, 5 * time.Minute, 4, 5 * time.Minute},
+		{"past the shift width", 30 * time.Second, 5 * time.Minute, 70, 5 * time.Minute},
+		{"many ejections", 30 * time.Second, 5 * time.Minute, math.MaxInt32, 5 * time.Minute},
+		{"cap near the largest duration", time.Second, math.MaxInt64, 200, math.MaxInt64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewOutlierDetector(OutlierConfig{BaseEjection: tt.base, MaxEjection: tt.max}, &ServerPool{})
+			if got := d.ejectionDuration(tt.ejections); got != tt.want {
+				t.Fatalf("ejectionDuration(%d) = %v, want %v", tt.ejections, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutlierEjectionsStopAtTheCap(t *testing.T) {
+	pool := &ServerPool{}
+	backends := []*Backend{testBackend("a", 0, 0, 0), testBackend("b", 0, 0, 0)}
+	for _, b := range backends {
+		if err := pool.AddBackend(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := NewOutlierDetector(OutlierConfig{
+		Consecutive5xx:     1,
+		BaseEjection:       time.Second,
+		MaxEjection:        4 * time.Second,
+		MaxEjectionPercent: 50,
+	}, pool)
+
+	b := backends[0]
+	for i := 0; i < 10; i++ {
+		b.Eject(time.Now())
+		d.ReportResponse(b, 500)
+	}
+	// 1s, 2s and 4s; the later ejections stay at the cap without counting.
+	if b.outlier.ejections != 2 {
+		t.Fatalf("ejections = %d, want 2", b.outlier.ejections)
+	}
+}
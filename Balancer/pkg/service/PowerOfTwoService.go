@@ -0,0 +1,59 @@
+package service
+
+import (
+	"math/rand"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// PowerOfTwoPool samples two alive backends at random and sends the request
+// to the one with the lower load score, see Backend.Score.
+type PowerOfTwoPool struct {
+	ServerPool
+}
+
+func (s *PowerOfTwoPool) GetNextServer(r *http.Request) *Backend {
+	backends := s.GetBackends()
+	alive := make([]*Backend, 0, len(backends))
+	for _, b := range backends {
+		if b.IsAvailable() {
+			alive = append(alive, b)
+		}
+	}
+
+	switch len(alive) {
+	case 0:
+		return nil
+	case 1:
+		return alive[0]
+	}
+
+	i := rand.Intn(len(alive))
+	j := rand.Intn(len(alive) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := alive[i], alive[j]
+	baseline := medianLatency(alive)
+	if b.Score(baseline) < a.Score(baseline) {
+		return b
+	}
+	return a
+}
+
+// medianLatency is the median smoothed latency of the backends that have
+// latency samples, or zero if none has.
+func medianLatency(backends []*Backend) time.Duration {
+	samples := make([]time.Duration, 0, len(backends))
+	for _, b := range backends {
+		if l := b.Latency(); l > 0 {
+			samples = append(samples, l)
+		}
+	}
+	if len(samples) == 0 {
+		return 0
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples[len(samples)/2]
+}
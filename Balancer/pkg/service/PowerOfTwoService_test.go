@@ -0,0 +1,142 @@
+package service
+
+import (
+	"math"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func testBackend(host string, latency time.Duration, idle time.Duration, inFlight int64) *Backend {
+	return &Backend{
+		URL:         &url.URL{Scheme: "http", Host: host},
+		Alive:       true,
+		ewmaLatency: float64(latency),
+		lastSample:  time.Now().Add(-idle),
+		inFlight:    inFlight,
+	}
+}
+
+func TestBackendScore(t *testing.T) {
+	tests := []struct {
+		name     string
+		latency  time.Duration
+		idle     time.Duration
+		inFlight int64
+		baseline time.Duration
+		want     float64
+	}{
+		{"no latency known", 0, 0, 2, 0, 3},
+		{"new backend gets the baseline", 0, 0, 0, 100 * time.Millisecond, float64(100 * time.Millisecond)},
+		{"new backend with queued requests", 0, 0, 2, 100 * time.Millisecond, float64(300 * time.Millisecond)},
+		{"fresh sample", 50 * time.Millisecond, 0, 0, 100 * time.Millisecond, float64(50 * time.Millisecond)},
+		{"fresh sample with queued requests", 50 * time.Millisecond, 0, 1, 100 * time.Millisecond, float64(100 * time.Millisecond)},
+		{"one time constant idle", time.Second, ewmaIdleDecay, 0, 0, float64(time.Second)},
+		{"idle drifts to the baseline", time.Second, ewmaIdleDecay, 0, 100 * time.Millisecond,
+			float64(100*time.Millisecond) + float64(900*time.Millisecond)/math.E},
+		{"long idle reaches the baseline", time.Second, time.Hour, 0, 100 * time.Millisecond, float64(100 * time.Millisecond)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := testBackend("a", tt.latency, tt.idle, tt.inFlight)
+			if got := b.Score(tt.baseline); math.Abs(got-tt.want) > tt.want*0.01 {
+				t.Fatalf("Score(%v) = %v, want %v", tt.baseline, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackendObserveLatency(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous time.Duration
+		idle     time.Duration
+		sample   time.Duration
+		want     time.Duration
+	}{
+		{"first sample", 0, 0, 80 * time.Millisecond, 80 * time.Millisecond},
+		{"back to back samples", 100 * time.Millisecond, 0, 200 * time.Millisecond, 130 * time.Millisecond},
+		{"sample after long idle", time.Second, time.Hour, 20 * time.Millisecond, 20 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := testBackend("a", tt.previous, tt.idle, 0)
+			b.ObserveLatency(tt.sample)
+			if got := b.Latency(); math.Abs(float64(got-tt.want)) > float64(time.Millisecond) {
+				t.Fatalf("Latency() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMedianLatency(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name      string
+		latencies []time.Duration
+		want      time.Duration
+	}{
+		{"no backends", nil, 0},
+		{"no samples", []time.Duration{0, 0}, 0},
+		{"unsampled backends are ignored", []time.Duration{0, 10 * ms, 30 * ms, 20 * ms}, 20 * ms},
+		{"even count takes the upper middle", []time.Duration{10 * ms, 20 * ms}, 20 * ms},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var backends []*Backend
+			for _, l := range tt.latencies {
+				backends = append(backends, testBackend("a", l, 0, 0))
+			}
+			if got := medianLatency(backends); got != tt.want {
+				t.Fatalf("medianLatency = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPowerOfTwoPick(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name     string
+		backends []*Backend
+		want     string
+	}{
+		{"lower latency wins", []*Backend{
+			testBackend("fast", 10*ms, 0, 0),
+			testBackend("slow", 100*ms, 0, 0),
+		}, "fast"},
+		{"queued requests outweigh latency", []*Backend{
+			testBackend("fast", 10*ms, 0, 20),
+			testBackend("slow", 100*ms, 0, 0),
+		}, "slow"},
+		{"new backend does not win while busy", []*Backend{
+			testBackend("new", 0, 0, 5),
+			testBackend("old", 10*ms, 0, 1),
+		}, "old"},
+		{"new backend is tried when idle", []*Backend{
+			testBackend("new", 0, 0, 0),
+			testBackend("old", 10*ms, 0, 3),
+		}, "new"},
+		{"unavailable backends are skipped", []*Backend{
+			{URL: &url.URL{Host: "down"}},
+			testBackend("up", time.Second, 0, 10),
+		}, "up"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &PowerOfTwoPool{}
+			for _, b := range tt.backends {
+				if err := pool.AddBackend(b); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// With two candidates both are always sampled, so the pick
+			// depends on the scores only.
+			for i := 0; i < 20; i++ {
+				if got := pool.GetNextServer(nil); got == nil || got.URL.Host != tt.want {
+					t.Fatalf("picked %v, want %s", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"net/http"
+	"sync"
+)
+
+// WeightedRoundRobinPool implements the smooth weighted round-robin used by
+// nginx: every pick each alive backend gains its weight, the richest one is
+// chosen and pays back the total weight of all alive backends.
+type WeightedRoundRobinPool struct {
+	ServerPool
+	mu sync.Mutex
+}
+
+func (s *WeightedRoundRobinPool) GetNextServer(r *http.Request) *Backend {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var best *Backend
+	total := 0
+	for _, b := range s.GetBackends() {
+		if !b.IsAvailable() {
+			// Forget accumulated credit so a recovered backend does not
+			// receive a burst of requests when it comes back.
+			b.currentWeight = 0
+			continue
+		}
+		weight := b.GetWeight()
+		b.currentWeight += weight
+		total += weight
+		if best == nil || b.currentWeight > best.currentWeight {
+			best = b
+		}
+	}
+
+	if best != nil {
+		best.currentWeight -= total
+	}
+	return best
+}
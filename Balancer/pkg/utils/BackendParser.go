@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ParseBackend parses a backend definition of the form
+// "http://host:port;weight=5". Weight defaults to 1 when omitted.
+func ParseBackend(spec string) (*url.URL, int, error) {
+	parts := strings.Split(strings.TrimSpace(spec), ";")
+	backendUrl, err := url.Parse(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return nil, 0, err
+	}
+	if backendUrl.Scheme == "" || backendUrl.Host == "" {
+		return nil, 0, fmt.Errorf("backend URL %q must include scheme and host", parts[0])
+	}
+
+	weight := 1
+	for _, opt := range parts[1:] {
+		key, value, found := strings.Cut(strings.TrimSpace(opt), "=")
+		if !found {
+			return nil, 0, fmt.Errorf("malformed backend option %q", opt)
+		}
+		switch strings.TrimSpace(key) {
+		case "weight":
+			weight, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || weight < 1 {
+				return nil, 0, fmt.Errorf("invalid weight %q: must be a positive integer", value)
+			}
+		default:
+			return nil, 0, fmt.Errorf("unknown backend option %q", key)
+		}
+	}
+	return backendUrl, weight, nil
+}
@@ -2,11 +2,13 @@ package utils
 
 import (
 	"net/http"
+	"time"
 )
 
 const (
 	Attempts int = iota
 	Retry
+	StartTime
 )
 
 func GetAttemptsFromContext(r *http.Request) int {
@@ -22,3 +24,10 @@ func GetRetryFromContext(r *http.Request) int {
 	}
 	return 0
 }
+
+func GetStartTimeFromContext(r *http.Request) time.Time {
+	if start, ok := r.Context().Value(StartTime).(time.Time); ok {
+		return start
+	}
+	return time.Now()
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ProbeTCP  = "tcp"
+	ProbeHTTP = "http"
+)
+
+// maxProbeBody bounds how much of a health response is read when looking
+// for the expected body substring.
+const maxProbeBody = 64 * 1024
+
+type HealthCheckConfig struct {
+	Interval     time.Duration
+	Type         string
+	Method       string
+	Path         string
+	StatusMin    int
+	StatusMax    int
+	BodyContains string
+	Timeout      time.Duration
+	Rise         int
+	Fall         int
+}
+
+func DefaultHealthCheckConfig() HealthCheckConfig {
+	return HealthCheckConfig{
+		Interval:  2 * time.Minute,
+		Type:      ProbeTCP,
+		Method:    http.MethodGet,
+		Path:      "/",
+		StatusMin: 200,
+		StatusMax: 399,
+		Timeout:   2 * time.Second,
+		Rise:      1,
+		Fall:      1,
+	}
+}
+
+// ParseStatusRange parses "200", "200-399" or "2xx" into an inclusive range.
+func ParseStatusRange(s string) (int, int, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 3 && strings.HasSuffix(strings.ToLower(s), "xx") {
+		class, err := strconv.Atoi(s[:1])
+		if err != nil || class < 1 || class > 5 {
+			return 0, 0, fmt.Errorf("invalid status class %q", s)
+		}
+		return class * 100, class*100 + 99, nil
+	}
+
+	lo, hi, found := strings.Cut(s, "-")
+	min, err := strconv.Atoi(strings.TrimSpace(lo))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid status range %q", s)
+	}
+	max := min
+	if found {
+		max, err = strconv.Atoi(strings.TrimSpace(hi))
+		if err != nil || max < min {
+			return 0, 0, fmt.Errorf("invalid status range %q", s)
+		}
+	}
+	return min, max, nil
+}
+
+func Probe(u *url.URL, cfg HealthCheckConfig) bool {
+	if cfg.Type == ProbeHTTP {
+		return TryHTTP(u, cfg)
+	}
+	return TryToConnect(u, cfg.Timeout)
+}
+
+func TryHTTP(u *url.URL, cfg HealthCheckConfig) bool {
+	target := *u
+	target.Path = cfg.Path
+	target.RawQuery = ""
+
+	client := &http.Client{Timeout: cfg.Timeout}
+	req, err := http.NewRequest(cfg.Method, target.String(), nil)
+	if err != nil {
+		log.Println("Failed to build health request, error: ", err)
+		return false
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Println("Health request failed, error: ", err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < cfg.StatusMin || resp.StatusCode > cfg.StatusMax {
+		log.Printf("%s unexpected health status %d\n", target.String(), resp.StatusCode)
+		return false
+	}
+
+	if cfg.BodyContains == "" {
+		return true
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+	if err != nil {
+		log.Println("Failed to read health response, error: ", err)
+		return false
+	}
+	if !strings.Contains(string(body), cfg.BodyContains) {
+		log.Printf("%s health response does not contain %q\n", target.String(), cfg.BodyContains)
+		return false
+	}
+	return true
+}
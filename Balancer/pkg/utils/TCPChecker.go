@@ -7,8 +7,7 @@ import (
 	"time"
 )
 
-func TryToConnect(u *url.URL) bool {
-	timeout := 2 * time.Second
+func TryToConnect(u *url.URL, timeout time.Duration) bool {
 	conn, err := net.DialTimeout("tcp", u.Host, timeout)
 	if err != nil {
 		log.Println("Site unreachable, error: ", err)
@@ -1,14 +1,50 @@
 package config
 
 import (
+	"LoadBalancer/TimeLimiter/pkg/model"
+	"encoding/json"
+	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	DatabaseURL string
-	Port        int
+	DatabaseURL        string
+	Port               int
+	AdminAddr          string
+	AdminToken         string
+	CostRules          []model.CostRule
+	CostResponseHeader string
+	PersistInterval    time.Duration
+	RateLimitMode      string
+	LeaseSize          float64
+	LeaseSyncInterval  time.Duration
+	ClientSyncInterval time.Duration
+	QuotaFlushInterval time.Duration
+	AuthMode           string
+	ApiKeyCacheTTL     time.Duration
+	JWTJWKSFile        string
+	JWTJWKSURL         string
+	JWTAudience        string
+	JWTIssuer          string
+	JWTClientIDClaim   string
+	JWTCapacityClaim   string
+	JWTRateClaim       string
+	JWTLeeway          time.Duration
+	JWTJWKSRefresh     time.Duration
+	UnknownClientMode  string
+	UnknownClientCode  int
+	AnonCapacity       int
+	AnonRatePerSec     float64
+	AnonIdleTimeout    time.Duration
+	AnonMaxBuckets     int
+	TrustedProxies     []*net.IPNet
+	MetricsPerClient   bool
 }
 
 func LoadConfig() (*Config, error) {
@@ -28,8 +64,250 @@ func LoadConfig() (*Config, error) {
 		return nil, err
 	}
 
+	adminAddr := os.Getenv("ADMIN_ADDR")
+	if adminAddr == "" {
+		adminAddr = "127.0.0.1:3031"
+		log.Println("Warning: ADMIN_ADDR not set. Using default:", adminAddr)
+	}
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		log.Println("Warning: ADMIN_TOKEN not set. The admin API is only protected by ADMIN_ADDR")
+	}
+
+	costRules, err := loadCostRules(os.Getenv("COST_RULES_FILE"))
+	if err != nil {
+		return nil, err
+	}
+
+	costHeader, ok := os.LookupEnv("COST_RESPONSE_HEADER")
+	if !ok {
+		costHeader = "X-RateLimit-Cost"
+	}
+
+	persistInterval := 10 * time.Second
+	if raw := os.Getenv("STATE_PERSIST_INTERVAL"); raw != "" {
+		persistInterval, err = time.ParseDuration(raw)
+		if err != nil || persistInterval <= 0 {
+			return nil, fmt.Errorf("invalid STATE_PERSIST_INTERVAL %q", raw)
+		}
+	}
+
+	mode := os.Getenv("RATE_LIMIT_MODE")
+	if mode == "" {
+		mode = "local"
+	}
+
+	leaseSize := 10.0
+	if raw := os.Getenv("LEASE_SIZE"); raw != "" {
+		leaseSize, err = strconv.ParseFloat(raw, 64)
+		if err != nil || leaseSize < 1 {
+			return nil, fmt.Errorf("invalid LEASE_SIZE %q", raw)
+		}
+	}
+
+	leaseSync := time.Second
+	if raw := os.Getenv("LEASE_SYNC_INTERVAL"); raw != "" {
+		leaseSync, err = time.ParseDuration(raw)
+		if err != nil || leaseSync <= 0 {
+			return nil, fmt.Errorf("invalid LEASE_SYNC_INTERVAL %q", raw)
+		}
+	}
+
+	clientSync := time.Minute
+	if raw := os.Getenv("CLIENT_SYNC_INTERVAL"); raw != "" {
+		clientSync, err = time.ParseDuration(raw)
+		if err != nil || clientSync <= 0 {
+			return nil, fmt.Errorf("invalid CLIENT_SYNC_INTERVAL %q", raw)
+		}
+	}
+
+	quotaFlush := 5 * time.Second
+	if raw := os.Getenv("QUOTA_FLUSH_INTERVAL"); raw != "" {
+		quotaFlush, err = time.ParseDuration(raw)
+		if err != nil || quotaFlush <= 0 {
+			return nil, fmt.Errorf("invalid QUOTA_FLUSH_INTERVAL %q", raw)
+		}
+	}
+
+	authMode := os.Getenv("AUTH_MODE")
+	if authMode == "" {
+		authMode = "api_key"
+	}
+
+	keyCacheTTL := 30 * time.Second
+	if raw := os.Getenv("API_KEY_CACHE_TTL"); raw != "" {
+		keyCacheTTL, err = time.ParseDuration(raw)
+		if err != nil || keyCacheTTL < 0 {
+			return nil, fmt.Errorf("invalid API_KEY_CACHE_TTL %q", raw)
+		}
+	}
+
+	jwtClientClaim := os.Getenv("JWT_CLIENT_ID_CLAIM")
+	if jwtClientClaim == "" {
+		jwtClientClaim = "sub"
+	}
+
+	jwtLeeway := 30 * time.Second
+	if raw := os.Getenv("JWT_LEEWAY"); raw != "" {
+		jwtLeeway, err = time.ParseDuration(raw)
+		if err != nil || jwtLeeway < 0 {
+			return nil, fmt.Errorf("invalid JWT_LEEWAY %q", raw)
+		}
+	}
+
+	jwksRefresh := 5 * time.Minute
+	if raw := os.Getenv("JWT_JWKS_REFRESH"); raw != "" {
+		jwksRefresh, err = time.ParseDuration(raw)
+		if err != nil || jwksRefresh < 0 {
+			return nil, fmt.Errorf("invalid JWT_JWKS_REFRESH %q", raw)
+		}
+	}
+
+	unknownMode := os.Getenv("UNKNOWN_CLIENT_MODE")
+	if unknownMode == "" {
+		unknownMode = "reject"
+	}
+	if unknownMode != "reject" && unknownMode != "anonymous" {
+		return nil, fmt.Errorf("invalid UNKNOWN_CLIENT_MODE %q", unknownMode)
+	}
+
+	unknownCode := http.StatusForbidden
+	if raw := os.Getenv("UNKNOWN_CLIENT_STATUS"); raw != "" {
+		unknownCode, err = strconv.Atoi(raw)
+		if err != nil || (unknownCode != http.StatusUnauthorized && unknownCode != http.StatusForbidden) {
+			return nil, fmt.Errorf("invalid UNKNOWN_CLIENT_STATUS %q, must be 401 or 403", raw)
+		}
+	}
+
+	anonCapacity := 10
+	if raw := os.Getenv("ANON_CAPACITY"); raw != "" {
+		anonCapacity, err = strconv.Atoi(raw)
+		if err != nil || anonCapacity < 1 {
+			return nil, fmt.Errorf("invalid ANON_CAPACITY %q", raw)
+		}
+	}
+
+	anonRate := 1.0
+	if raw := os.Getenv("ANON_RATE_PER_SEC"); raw != "" {
+		anonRate, err = strconv.ParseFloat(raw, 64)
+		if err != nil || anonRate <= 0 {
+			return nil, fmt.Errorf("invalid ANON_RATE_PER_SEC %q", raw)
+		}
+	}
+
+	anonIdle := 10 * time.Minute
+	if raw := os.Getenv("ANON_IDLE_TIMEOUT"); raw != "" {
+		anonIdle, err = time.ParseDuration(raw)
+		if err != nil || anonIdle <= 0 {
+			return nil, fmt.Errorf("invalid ANON_IDLE_TIMEOUT %q", raw)
+		}
+	}
+
+	anonMaxBuckets := 100000
+	if raw := os.Getenv("ANON_MAX_BUCKETS"); raw != "" {
+		anonMaxBuckets, err = strconv.Atoi(raw)
+		if err != nil || anonMaxBuckets < 1 {
+			return nil, fmt.Errorf("invalid ANON_MAX_BUCKETS %q", raw)
+		}
+	}
+
+	// The per-client metrics have a series per client, which only suits a small
+	// number of clients.
+	metricsPerClient := false
+	if raw := os.Getenv("METRICS_PER_CLIENT"); raw != "" {
+		metricsPerClient, err = strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid METRICS_PER_CLIENT %q", raw)
+		}
+	}
+
+	trustedProxies, err := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return nil, err
+	}
+
 	return &Config{
-		DatabaseURL: dbURL,
-		Port:        port,
+		DatabaseURL:        dbURL,
+		Port:               port,
+		AdminAddr:          adminAddr,
+		AdminToken:         adminToken,
+		CostRules:          costRules,
+		CostResponseHeader: costHeader,
+		PersistInterval:    persistInterval,
+		RateLimitMode:      mode,
+		LeaseSize:          leaseSize,
+		LeaseSyncInterval:  leaseSync,
+		ClientSyncInterval: clientSync,
+		QuotaFlushInterval: quotaFlush,
+		AuthMode:           authMode,
+		ApiKeyCacheTTL:     keyCacheTTL,
+		JWTJWKSFile:        os.Getenv("JWT_JWKS_FILE"),
+		JWTJWKSURL:         os.Getenv("JWT_JWKS_URL"),
+		JWTAudience:        os.Getenv("JWT_AUDIENCE"),
+		JWTIssuer:          os.Getenv("JWT_ISSUER"),
+		JWTClientIDClaim:   jwtClientClaim,
+		JWTCapacityClaim:   os.Getenv("JWT_CAPACITY_CLAIM"),
+		JWTRateClaim:       os.Getenv("JWT_RATE_CLAIM"),
+		JWTLeeway:          jwtLeeway,
+		JWTJWKSRefresh:     jwksRefresh,
+		UnknownClientMode:  unknownMode,
+		UnknownClientCode:  unknownCode,
+		AnonCapacity:       anonCapacity,
+		AnonRatePerSec:     anonRate,
+		AnonIdleTimeout:    anonIdle,
+		AnonMaxBuckets:     anonMaxBuckets,
+		TrustedProxies:     trustedProxies,
+		MetricsPerClient:   metricsPerClient,
 	}, nil
 }
+
+// parseTrustedProxies reads a comma separated list of addresses and CIDR
+// ranges, e.g. "10.0.0.0/8,192.168.1.1".
+func parseTrustedProxies(raw string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func loadCostRules(path string) ([]model.CostRule, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cost rules: %w", err)
+	}
+	var rules []model.CostRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse cost rules: %w", err)
+	}
+	for i, rule := range rules {
+		if rule.Cost < 0 {
+			return nil, fmt.Errorf("cost rule %d: cost must not be negative", i)
+		}
+	}
+	log.Printf("Loaded %d cost rules from %s", len(rules), path)
+	return rules, nil
+}
@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"LoadBalancer/TimeLimiter/pkg/model"
+	"LoadBalancer/TimeLimiter/pkg/service"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const defaultKeyOverlap = 24 * time.Hour
+
+type ApiKeyController interface {
+	ListKeys(w http.ResponseWriter, r *http.Request)
+	IssueKey(w http.ResponseWriter, r *http.Request)
+	RotateKey(w http.ResponseWriter, r *http.Request)
+	RevokeKey(w http.ResponseWriter, r *http.Request)
+}
+
+type ApiKeyControllerImpl struct {
+	keys *service.ApiKeyService
+}
+
+// keyRequest holds the optional key lifetime and, for rotation, how long
+// the previous keys stay valid, as Go durations such as "720h".
+type keyRequest struct {
+	TTL     string `json:"ttl"`
+	Overlap string `json:"overlap"`
+}
+
+func NewApiKeyControllerImpl(keys *service.ApiKeyService) *ApiKeyControllerImpl {
+	return &ApiKeyControllerImpl{
+		keys: keys,
+	}
+}
+
+func (con *ApiKeyControllerImpl) ListKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := con.keys.List(mux.Vars(r)["client_id"])
+	if err != nil {
+		log.Printf("ListKeys: Error listing API keys: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(keys); err != nil {
+		log.Printf("ListKeys: Error encoding response: %v", err)
+	}
+}
+
+func (con *ApiKeyControllerImpl) IssueKey(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("IssueKey: Request received at %s", startTime.Format(time.RFC3339))
+
+	req, err := decodeKeyRequest(r)
+	if err != nil {
+		log.Printf("IssueKey: Invalid request: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ttl, err := parseOptionalDuration("ttl", req.TTL, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key, err := con.keys.Issue(mux.Vars(r)["client_id"], ttl)
+	if err != nil {
+		log.Printf("IssueKey: Error issuing API key: %v", err)
+		http.Error(w, err.Error(), repoErrorStatus(err))
+		return
+	}
+
+	writeIssuedKey(w, key)
+	log.Printf("IssueKey: API key %d issued, status code: %d, duration: %v", key.ID, http.StatusCreated, time.Since(startTime))
+}
+
+func (con *ApiKeyControllerImpl) RotateKey(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("RotateKey: Request received at %s", startTime.Format(time.RFC3339))
+
+	req, err := decodeKeyRequest(r)
+	if err != nil {
+		log.Printf("RotateKey: Invalid request: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ttl, err := parseOptionalDuration("ttl", req.TTL, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	overlap, err := parseOptionalDuration("overlap", req.Overlap, defaultKeyOverlap)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key, err := con.keys.Rotate(mux.Vars(r)["client_id"], ttl, overlap)
+	if err != nil {
+		log.Printf("RotateKey: Error rotating API keys: %v", err)
+		http.Error(w, err.Error(), repoErrorStatus(err))
+		return
+	}
+
+	writeIssuedKey(w, key)
+	log.Printf("RotateKey: API key %d issued, old keys valid for %v, status code: %d, duration: %v", key.ID, overlap, http.StatusCreated, time.Since(startTime))
+}
+
+func (con *ApiKeyControllerImpl) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("RevokeKey: Request received at %s", startTime.Format(time.RFC3339))
+
+	vars := mux.Vars(r)
+	keyID, err := strconv.ParseInt(vars["key_id"], 10, 64)
+	if err != nil || keyID < 1 {
+		http.Error(w, "key_id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	if err := con.keys.Revoke(vars["client_id"], keyID); err != nil {
+		log.Printf("RevokeKey: Error revoking API key: %v", err)
+		http.Error(w, err.Error(), repoErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "API key revoked successfully")
+
+	log.Printf("RevokeKey: API key %d revoked, status code: %d, duration: %v", keyID, http.StatusOK, time.Since(startTime))
+}
+
+// decodeKeyRequest accepts an empty body, all fields are optional.
+func decodeKeyRequest(r *http.Request) (keyRequest, error) {
+	var req keyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		return req, err
+	}
+	return req, nil
+}
+
+func parseOptionalDuration(name string, raw string, def time.Duration) (time.Duration, error) {
+	if raw == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, raw)
+	}
+	return d, nil
+}
+
+func writeIssuedKey(w http.ResponseWriter, key model.IssuedKey) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(key); err != nil {
+		log.Printf("Error encoding issued API key: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package controller
+
+import (
+	"net/http"
+	"sync"
+)
+
+// costReportingWriter captures the cost header a backend puts on its
+// response and strips it before the response reaches the client.
+type costReportingWriter struct {
+	http.ResponseWriter
+	header string
+
+	// The proxy goroutine can still be writing when BalanceRequest gives
+	// up on a timed out request, so the captured header is guarded.
+	mu       sync.Mutex
+	reported http.Header
+}
+
+func newCostReportingWriter(w http.ResponseWriter, header string) *costReportingWriter {
+	return &costReportingWriter{
+		ResponseWriter: w,
+		header:         header,
+		reported:       http.Header{},
+	}
+}
+
+func (cw *costReportingWriter) WriteHeader(statusCode int) {
+	cw.capture()
+	cw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (cw *costReportingWriter) Write(b []byte) (int, error) {
+	cw.capture()
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *costReportingWriter) capture() {
+	if cw.header == "" {
+		return
+	}
+	h := cw.ResponseWriter.Header()
+	if value := h.Get(cw.header); value != "" {
+		cw.mu.Lock()
+		cw.reported.Set(cw.header, value)
+		cw.mu.Unlock()
+		h.Del(cw.header)
+	}
+}
+
+// Reported returns a copy of the cost header captured so far.
+func (cw *costReportingWriter) Reported() http.Header {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.reported.Clone()
+}
+
+func (cw *costReportingWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,138 @@
+package controller
+
+import (
+	"LoadBalancer/TimeLimiter/pkg/model"
+	"LoadBalancer/TimeLimiter/pkg/service"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type OrganizationController interface {
+	ListOrgs(w http.ResponseWriter, r *http.Request)
+	GetOrg(w http.ResponseWriter, r *http.Request)
+	AddOrg(w http.ResponseWriter, r *http.Request)
+	UpdateOrg(w http.ResponseWriter, r *http.Request)
+	DeleteOrg(w http.ResponseWriter, r *http.Request)
+}
+
+type OrganizationControllerImpl struct {
+	userSevice *service.UserserviceImpl
+}
+
+func NewOrganizationControllerImpl(userSevice *service.UserserviceImpl) *OrganizationControllerImpl {
+	return &OrganizationControllerImpl{
+		userSevice: userSevice,
+	}
+}
+
+func (con *OrganizationControllerImpl) ListOrgs(w http.ResponseWriter, r *http.Request) {
+	orgs, err := con.userSevice.ListOrgs()
+	if err != nil {
+		log.Printf("ListOrgs: Error listing organizations: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(orgs); err != nil {
+		log.Printf("ListOrgs: Error encoding response: %v", err)
+	}
+}
+
+func (con *OrganizationControllerImpl) GetOrg(w http.ResponseWriter, r *http.Request) {
+	orgID := mux.Vars(r)["org_id"]
+	org, found, err := con.userSevice.GetOrg(orgID)
+	if err != nil {
+		log.Printf("GetOrg: Error loading organization: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, fmt.Sprintf("organization with ID %s not found", orgID), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(org); err != nil {
+		log.Printf("GetOrg: Error encoding response: %v", err)
+	}
+}
+
+func (con *OrganizationControllerImpl) AddOrg(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("AddOrg: Request received at %s", startTime.Format(time.RFC3339))
+
+	var org model.Organization
+	if err := json.NewDecoder(r.Body).Decode(&org); err != nil {
+		log.Printf("AddOrg: Error decoding request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := org.Validate(); err != nil {
+		log.Printf("AddOrg: Invalid organization: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := con.userSevice.AddOrg(org); err != nil {
+		log.Printf("AddOrg: Error adding organization to repository: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, "Organization added successfully")
+
+	log.Printf("AddOrg: Organization added successfully, status code: %d, duration: %v", http.StatusCreated, time.Since(startTime))
+}
+
+func (con *OrganizationControllerImpl) UpdateOrg(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("UpdateOrg: Request received at %s", startTime.Format(time.RFC3339))
+
+	var org model.Organization
+	if err := json.NewDecoder(r.Body).Decode(&org); err != nil {
+		log.Printf("UpdateOrg: Error decoding request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := org.Validate(); err != nil {
+		log.Printf("UpdateOrg: Invalid organization: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := con.userSevice.UpdateOrg(org); err != nil {
+		log.Printf("UpdateOrg: Error updating organization in repository: %v", err)
+		http.Error(w, err.Error(), repoErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "Organization updated successfully")
+
+	log.Printf("UpdateOrg: Organization updated successfully, status code: %d, duration: %v", http.StatusOK, time.Since(startTime))
+}
+
+func (con *OrganizationControllerImpl) DeleteOrg(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("DeleteOrg: Request received at %s", startTime.Format(time.RFC3339))
+
+	if err := con.userSevice.DeleteOrg(mux.Vars(r)["org_id"]); err != nil {
+		log.Printf("DeleteOrg: Error deleting organization from repository: %v", err)
+		http.Error(w, err.Error(), repoErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "Organization deleted successfully")
+
+	log.Printf("DeleteOrg: Organization deleted successfully, status code: %d, duration: %v", http.StatusOK, time.Since(startTime))
+}
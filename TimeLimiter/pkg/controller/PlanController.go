@@ -0,0 +1,345 @@
+package controller
+
+import (
+	"LoadBalancer/TimeLimiter/pkg/model"
+	"LoadBalancer/TimeLimiter/pkg/service"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type PlanController interface {
+	ListPlans(w http.ResponseWriter, r *http.Request)
+	GetPlan(w http.ResponseWriter, r *http.Request)
+	AddPlan(w http.ResponseWriter, r *http.Request)
+	UpdatePlan(w http.ResponseWriter, r *http.Request)
+	DeletePlan(w http.ResponseWriter, r *http.Request)
+	ListPlanPolicies(w http.ResponseWriter, r *http.Request)
+	AddPlanPolicy(w http.ResponseWriter, r *http.Request)
+	UpdatePlanPolicy(w http.ResponseWriter, r *http.Request)
+	DeletePlanPolicy(w http.ResponseWriter, r *http.Request)
+	AddPlanQuota(w http.ResponseWriter, r *http.Request)
+	DeletePlanQuota(w http.ResponseWriter, r *http.Request)
+}
+
+type PlanControllerImpl struct {
+	userSevice *service.UserserviceImpl
+}
+
+func NewPlanControllerImpl(userSevice *service.UserserviceImpl) *PlanControllerImpl {
+	return &PlanControllerImpl{
+		userSevice: userSevice,
+	}
+}
+
+func (con *PlanControllerImpl) ListPlans(w http.ResponseWriter, r *http.Request) {
+	plans, err := con.userSevice.ListPlans()
+	if err != nil {
+		log.Printf("ListPlans: Error listing plans: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(plans); err != nil {
+		log.Printf("ListPlans: Error encoding response: %v", err)
+	}
+}
+
+func (con *PlanControllerImpl) GetPlan(w http.ResponseWriter, r *http.Request) {
+	planID := mux.Vars(r)["plan_id"]
+	plan, found, err := con.userSevice.GetPlan(planID)
+	if err != nil {
+		log.Printf("GetPlan: Error loading plan: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, fmt.Sprintf("plan with ID %s not found", planID), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(plan); err != nil {
+		log.Printf("GetPlan: Error encoding response: %v", err)
+	}
+}
+
+// AddPlan creates the plan together with the quotas and route policies
+// given in the request.
+func (con *PlanControllerImpl) AddPlan(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("AddPlan: Request received at %s", startTime.Format(time.RFC3339))
+
+	var plan model.Plan
+	if err := json.NewDecoder(r.Body).Decode(&plan); err != nil {
+		log.Printf("AddPlan: Error decoding request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validatePlan(&plan); err != nil {
+		log.Printf("AddPlan: Invalid plan: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := con.userSevice.AddPlan(plan); err != nil {
+		log.Printf("AddPlan: Error adding plan to repository: %v", err)
+		http.Error(w, err.Error(), repoErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, "Plan added successfully")
+
+	log.Printf("AddPlan: Plan added successfully, status code: %d, duration: %v", http.StatusCreated, time.Since(startTime))
+}
+
+// UpdatePlan changes the plan's bucket settings; quotas and route policies
+// are changed through their own endpoints.
+func (con *PlanControllerImpl) UpdatePlan(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("UpdatePlan: Request received at %s", startTime.Format(time.RFC3339))
+
+	var plan model.Plan
+	if err := json.NewDecoder(r.Body).Decode(&plan); err != nil {
+		log.Printf("UpdatePlan: Error decoding request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(plan.Quotas) > 0 || len(plan.Policies) > 0 {
+		http.Error(w, "quotas and policies of a plan are updated through /plans/{plan_id}/quotas and /plans/{plan_id}/policies", http.StatusBadRequest)
+		return
+	}
+
+	if err := plan.Validate(); err != nil {
+		log.Printf("UpdatePlan: Invalid plan: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := con.userSevice.UpdatePlan(plan); err != nil {
+		log.Printf("UpdatePlan: Error updating plan in repository: %v", err)
+		http.Error(w, err.Error(), repoErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "Plan updated successfully")
+
+	log.Printf("UpdatePlan: Plan updated successfully, status code: %d, duration: %v", http.StatusOK, time.Since(startTime))
+}
+
+func (con *PlanControllerImpl) DeletePlan(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("DeletePlan: Request received at %s", startTime.Format(time.RFC3339))
+
+	if err := con.userSevice.DeletePlan(mux.Vars(r)["plan_id"]); err != nil {
+		log.Printf("DeletePlan: Error deleting plan from repository: %v", err)
+		http.Error(w, err.Error(), repoErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "Plan deleted successfully")
+
+	log.Printf("DeletePlan: Plan deleted successfully, status code: %d, duration: %v", http.StatusOK, time.Since(startTime))
+}
+
+func (con *PlanControllerImpl) ListPlanPolicies(w http.ResponseWriter, r *http.Request) {
+	policies, err := con.userSevice.ListPlanPolicies(mux.Vars(r)["plan_id"])
+	if err != nil {
+		log.Printf("ListPlanPolicies: Error listing route policies: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(policies); err != nil {
+		log.Printf("ListPlanPolicies: Error encoding response: %v", err)
+	}
+}
+
+func (con *PlanControllerImpl) AddPlanPolicy(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("AddPlanPolicy: Request received at %s", startTime.Format(time.RFC3339))
+
+	var policy model.RoutePolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		log.Printf("AddPlanPolicy: Error decoding request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	policy.PlanID = mux.Vars(r)["plan_id"]
+	policy.ClientID = ""
+	policy.ID = 0
+
+	if err := policy.Validate(); err != nil {
+		log.Printf("AddPlanPolicy: Invalid route policy: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	policy, err := con.userSevice.AddPlanPolicy(policy)
+	if err != nil {
+		log.Printf("AddPlanPolicy: Error adding route policy: %v", err)
+		http.Error(w, err.Error(), repoErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(policy); err != nil {
+		log.Printf("AddPlanPolicy: Error encoding response: %v", err)
+	}
+
+	log.Printf("AddPlanPolicy: Route policy added successfully, status code: %d, duration: %v", http.StatusCreated, time.Since(startTime))
+}
+
+func (con *PlanControllerImpl) UpdatePlanPolicy(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("UpdatePlanPolicy: Request received at %s", startTime.Format(time.RFC3339))
+
+	policyID, err := parsePolicyID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var policy model.RoutePolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		log.Printf("UpdatePlanPolicy: Error decoding request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	policy.PlanID = mux.Vars(r)["plan_id"]
+	policy.ClientID = ""
+	policy.ID = policyID
+
+	if err := policy.Validate(); err != nil {
+		log.Printf("UpdatePlanPolicy: Invalid route policy: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := con.userSevice.UpdatePlanPolicy(policy); err != nil {
+		log.Printf("UpdatePlanPolicy: Error updating route policy: %v", err)
+		http.Error(w, err.Error(), repoErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "Route policy updated successfully")
+
+	log.Printf("UpdatePlanPolicy: Route policy updated successfully, status code: %d, duration: %v", http.StatusOK, time.Since(startTime))
+}
+
+func (con *PlanControllerImpl) DeletePlanPolicy(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("DeletePlanPolicy: Request received at %s", startTime.Format(time.RFC3339))
+
+	policyID, err := parsePolicyID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := con.userSevice.DeletePlanPolicy(mux.Vars(r)["plan_id"], policyID); err != nil {
+		log.Printf("DeletePlanPolicy: Error deleting route policy: %v", err)
+		http.Error(w, err.Error(), repoErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "Route policy deleted successfully")
+
+	log.Printf("DeletePlanPolicy: Route policy deleted successfully, status code: %d, duration: %v", http.StatusOK, time.Since(startTime))
+}
+
+func (con *PlanControllerImpl) AddPlanQuota(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("AddPlanQuota: Request received at %s", startTime.Format(time.RFC3339))
+
+	var quota model.Quota
+	if err := json.NewDecoder(r.Body).Decode(&quota); err != nil {
+		log.Printf("AddPlanQuota: Error decoding request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	quota.PlanID = mux.Vars(r)["plan_id"]
+	quota.ClientID = ""
+	quota.ID = 0
+
+	if err := quota.Validate(); err != nil {
+		log.Printf("AddPlanQuota: Invalid quota: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	quota, err := con.userSevice.AddQuota(quota)
+	if err != nil {
+		log.Printf("AddPlanQuota: Error adding quota: %v", err)
+		http.Error(w, err.Error(), repoErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(quota); err != nil {
+		log.Printf("AddPlanQuota: Error encoding response: %v", err)
+	}
+
+	log.Printf("AddPlanQuota: Quota added successfully, status code: %d, duration: %v", http.StatusCreated, time.Since(startTime))
+}
+
+func (con *PlanControllerImpl) DeletePlanQuota(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("DeletePlanQuota: Request received at %s", startTime.Format(time.RFC3339))
+
+	vars := mux.Vars(r)
+	quotaID, err := strconv.ParseInt(vars["quota_id"], 10, 64)
+	if err != nil || quotaID < 1 {
+		http.Error(w, "quota_id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	if err := con.userSevice.DeletePlanQuota(vars["plan_id"], quotaID); err != nil {
+		log.Printf("DeletePlanQuota: Error deleting quota: %v", err)
+		http.Error(w, err.Error(), repoErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "Quota deleted successfully")
+
+	log.Printf("DeletePlanQuota: Quota deleted successfully, status code: %d, duration: %v", http.StatusOK, time.Since(startTime))
+}
+
+// validatePlan checks the plan and the quotas and route policies it is
+// created with, and assigns them to the plan.
+func validatePlan(plan *model.Plan) error {
+	if err := plan.Validate(); err != nil {
+		return err
+	}
+	for i := range plan.Quotas {
+		q := &plan.Quotas[i]
+		q.ID, q.ClientID, q.PlanID = 0, "", plan.PlanID
+		if err := q.Validate(); err != nil {
+			return fmt.Errorf("quota %d: %w", i, err)
+		}
+	}
+	for i := range plan.Policies {
+		p := &plan.Policies[i]
+		p.ID, p.ClientID, p.PlanID = 0, "", plan.PlanID
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("policy %d: %w", i, err)
+		}
+	}
+	return nil
+}
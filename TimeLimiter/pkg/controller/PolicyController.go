@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"LoadBalancer/TimeLimiter/pkg/model"
+	"LoadBalancer/TimeLimiter/pkg/repository"
+	"LoadBalancer/TimeLimiter/pkg/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type PolicyController interface {
+	ListPolicies(w http.ResponseWriter, r *http.Request)
+	AddPolicy(w http.ResponseWriter, r *http.Request)
+	UpdatePolicy(w http.ResponseWriter, r *http.Request)
+	DeletePolicy(w http.ResponseWriter, r *http.Request)
+}
+
+type PolicyControllerImpl struct {
+	userSevice *service.UserserviceImpl
+}
+
+func NewPolicyControllerImpl(userSevice *service.UserserviceImpl) *PolicyControllerImpl {
+	return &PolicyControllerImpl{
+		userSevice: userSevice,
+	}
+}
+
+func (con *PolicyControllerImpl) ListPolicies(w http.ResponseWriter, r *http.Request) {
+	clientID := mux.Vars(r)["client_id"]
+	policies, err := con.userSevice.ListPolicies(clientID)
+	if err != nil {
+		log.Printf("ListPolicies: Error listing route policies: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(policies); err != nil {
+		log.Printf("ListPolicies: Error encoding response: %v", err)
+	}
+}
+
+func (con *PolicyControllerImpl) AddPolicy(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("AddPolicy: Request received at %s", startTime.Format(time.RFC3339))
+
+	var policy model.RoutePolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		log.Printf("AddPolicy: Error decoding request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	policy.ClientID = mux.Vars(r)["client_id"]
+	policy.ID = 0
+
+	if err := policy.Validate(); err != nil {
+		log.Printf("AddPolicy: Invalid route policy: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	policy, err := con.userSevice.AddPolicy(policy)
+	if err != nil {
+		log.Printf("AddPolicy: Error adding route policy: %v", err)
+		http.Error(w, err.Error(), repoErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(policy); err != nil {
+		log.Printf("AddPolicy: Error encoding response: %v", err)
+	}
+
+	log.Printf("AddPolicy: Route policy added successfully, status code: %d, duration: %v", http.StatusCreated, time.Since(startTime))
+}
+
+func (con *PolicyControllerImpl) UpdatePolicy(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("UpdatePolicy: Request received at %s", startTime.Format(time.RFC3339))
+
+	policyID, err := parsePolicyID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var policy model.RoutePolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		log.Printf("UpdatePolicy: Error decoding request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	policy.ClientID = mux.Vars(r)["client_id"]
+	policy.ID = policyID
+
+	if err := policy.Validate(); err != nil {
+		log.Printf("UpdatePolicy: Invalid route policy: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := con.userSevice.UpdatePolicy(policy); err != nil {
+		log.Printf("UpdatePolicy: Error updating route policy: %v", err)
+		http.Error(w, err.Error(), repoErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "Route policy updated successfully")
+
+	log.Printf("UpdatePolicy: Route policy updated successfully, status code: %d, duration: %v", http.StatusOK, time.Since(startTime))
+}
+
+func (con *PolicyControllerImpl) DeletePolicy(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("DeletePolicy: Request received at %s", startTime.Format(time.RFC3339))
+
+	policyID, err := parsePolicyID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := con.userSevice.DeletePolicy(mux.Vars(r)["client_id"], policyID); err != nil {
+		log.Printf("DeletePolicy: Error deleting route policy: %v", err)
+		http.Error(w, err.Error(), repoErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "Route policy deleted successfully")
+
+	log.Printf("DeletePolicy: Route policy deleted successfully, status code: %d, duration: %v", http.StatusOK, time.Since(startTime))
+}
+
+func parsePolicyID(r *http.Request) (int64, error) {
+	policyID, err := strconv.ParseInt(mux.Vars(r)["policy_id"], 10, 64)
+	if err != nil || policyID < 1 {
+		return 0, fmt.Errorf("policy_id must be a positive integer")
+	}
+	return policyID, nil
+}
+
+// repoErrorStatus maps a repository error to the response status.
+func repoErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, repository.ErrInvalidConfig):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"LoadBalancer/TimeLimiter/pkg/model"
+	"LoadBalancer/TimeLimiter/pkg/service"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type QuotaController interface {
+	AddQuota(w http.ResponseWriter, r *http.Request)
+	DeleteQuota(w http.ResponseWriter, r *http.Request)
+	GetUsage(w http.ResponseWriter, r *http.Request)
+}
+
+type QuotaControllerImpl struct {
+	userSevice *service.UserserviceImpl
+}
+
+func NewQuotaControllerImpl(userSevice *service.UserserviceImpl) *QuotaControllerImpl {
+	return &QuotaControllerImpl{
+		userSevice: userSevice,
+	}
+}
+
+func (con *QuotaControllerImpl) AddQuota(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("AddQuota: Request received at %s", startTime.Format(time.RFC3339))
+
+	var quota model.Quota
+	if err := json.NewDecoder(r.Body).Decode(&quota); err != nil {
+		log.Printf("AddQuota: Error decoding request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	quota.ClientID = mux.Vars(r)["client_id"]
+	quota.ID = 0
+
+	if err := quota.Validate(); err != nil {
+		log.Printf("AddQuota: Invalid quota: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	quota, err := con.userSevice.AddQuota(quota)
+	if err != nil {
+		log.Printf("AddQuota: Error adding quota: %v", err)
+		http.Error(w, err.Error(), repoErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(quota); err != nil {
+		log.Printf("AddQuota: Error encoding response: %v", err)
+	}
+
+	log.Printf("AddQuota: Quota added successfully, status code: %d, duration: %v", http.StatusCreated, time.Since(startTime))
+}
+
+func (con *QuotaControllerImpl) DeleteQuota(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("DeleteQuota: Request received at %s", startTime.Format(time.RFC3339))
+
+	vars := mux.Vars(r)
+	quotaID, err := strconv.ParseInt(vars["quota_id"], 10, 64)
+	if err != nil || quotaID < 1 {
+		http.Error(w, "quota_id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	if err := con.userSevice.DeleteQuota(vars["client_id"], quotaID); err != nil {
+		log.Printf("DeleteQuota: Error deleting quota: %v", err)
+		http.Error(w, err.Error(), repoErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "Quota deleted successfully")
+
+	log.Printf("DeleteQuota: Quota deleted successfully, status code: %d, duration: %v", http.StatusOK, time.Since(startTime))
+}
+
+// GetUsage reports every quota of the client with its usage in the current
+// window, as seen by this instance.
+func (con *QuotaControllerImpl) GetUsage(w http.ResponseWriter, r *http.Request) {
+	clientID := mux.Vars(r)["client_id"]
+	usage := con.userSevice.QuotaUsage(clientID)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(usage); err != nil {
+		log.Printf("GetUsage: Error encoding response: %v", err)
+	}
+}
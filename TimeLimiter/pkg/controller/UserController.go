@@ -2,11 +2,15 @@ package controller
 
 import (
 	"LoadBalancer/TimeLimiter/pkg/model"
+	"LoadBalancer/TimeLimiter/pkg/repository"
 	"LoadBalancer/TimeLimiter/pkg/service"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"LoadBalancer/Balancer/pkg/controller"
@@ -19,16 +23,25 @@ type UserController interface {
 	AddClient(w http.ResponseWriter, r *http.Request)
 	DeleteClient(w http.ResponseWriter, r *http.Request)
 	UpdateClient(w http.ResponseWriter, r *http.Request)
+	ListClients(w http.ResponseWriter, r *http.Request)
+	GetClient(w http.ResponseWriter, r *http.Request)
 }
 
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
 type UserControllerImpl struct {
 	userSevice   *service.UserserviceImpl
+	auth         service.Authenticator
 	LBcontroller controller.LoadBalancerController
 }
 
-func NewUserControllerImpl(userSevice *service.UserserviceImpl, LBcontroller controller.LoadBalancerController) *UserControllerImpl {
+func NewUserControllerImpl(userSevice *service.UserserviceImpl, auth service.Authenticator, LBcontroller controller.LoadBalancerController) *UserControllerImpl {
 	return &UserControllerImpl{
 		userSevice:   userSevice,
+		auth:         auth,
 		LBcontroller: LBcontroller,
 	}
 }
@@ -44,9 +57,19 @@ func (con *UserControllerImpl) AddClient(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if err := config.Validate(); err != nil {
+		log.Printf("AddClient: Invalid client config: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	if err := con.userSevice.AddClient(config); err != nil {
 		log.Printf("AddClient: Error adding client to repository: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		status := http.StatusInternalServerError
+		if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrInvalidConfig) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
 		return
 	}
 
@@ -86,9 +109,19 @@ func (con *UserControllerImpl) UpdateClient(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	if err := config.Validate(); err != nil {
+		log.Printf("UpdateClient: Invalid client config: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	if err := con.userSevice.UpdateClient(config); err != nil {
 		log.Printf("UpdateClient: Error updating client in repository: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		status := http.StatusInternalServerError
+		if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrInvalidConfig) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
 		return
 	}
 
@@ -98,27 +131,137 @@ func (con *UserControllerImpl) UpdateClient(w http.ResponseWriter, r *http.Reque
 	log.Printf("UpdateClient: Client updated successfully, status code: %d, duration: %v", http.StatusOK, time.Since(startTime))
 }
 
+func (con *UserControllerImpl) ListClients(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("ListClients: Request received at %s", startTime.Format(time.RFC3339))
+
+	query := r.URL.Query()
+	filter := model.ClientFilter{
+		Prefix:    query.Get("prefix"),
+		Algorithm: query.Get("algorithm"),
+		OrgID:     query.Get("org_id"),
+		PlanID:    query.Get("plan_id"),
+		Limit:     defaultPageLimit,
+	}
+	var err error
+	if raw := query.Get("limit"); raw != "" {
+		if filter.Limit, err = strconv.Atoi(raw); err != nil || filter.Limit < 1 || filter.Limit > maxPageLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxPageLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	if raw := query.Get("offset"); raw != "" {
+		if filter.Offset, err = strconv.Atoi(raw); err != nil || filter.Offset < 0 {
+			http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	page, err := con.userSevice.ListClients(filter)
+	if err != nil {
+		log.Printf("ListClients: Error listing clients: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		log.Printf("ListClients: Error encoding response: %v", err)
+	}
+
+	log.Printf("ListClients: Returned %d of %d clients, duration: %v", len(page.Clients), page.Total, time.Since(startTime))
+}
+
+func (con *UserControllerImpl) GetClient(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	log.Printf("GetClient: Request received at %s", startTime.Format(time.RFC3339))
+
+	clientID := mux.Vars(r)["client_id"]
+	status, found, err := con.userSevice.GetClient(clientID)
+	if err != nil {
+		log.Printf("GetClient: Error loading client: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, fmt.Sprintf("client with ID %s not found", clientID), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Printf("GetClient: Error encoding response: %v", err)
+	}
+
+	log.Printf("GetClient: Client %s returned, duration: %v", clientID, time.Since(startTime))
+}
+
 func (con *UserControllerImpl) CheckRateLimit(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 	log.Printf("CheckRateLimit: Request received at %s", startTime.Format(time.RFC3339))
 
-	clientID := r.URL.Query().Get("client_id")
-
-	if clientID == "" {
-		log.Println("CheckRateLimit: client_id is missing in the query parameters")
-		http.Error(w, "client_id is required", http.StatusBadRequest)
+	identity, err := con.auth.Authenticate(r)
+	if err != nil && con.userSevice.Anonymous != nil &&
+		(errors.Is(err, service.ErrNoCredentials) || errors.Is(err, service.ErrMissingClientID)) {
+		// Requests without credentials fall back to the anonymous tier.
+		identity, err = service.Identity{}, nil
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, service.ErrMissingClientID):
+			status = http.StatusBadRequest
+		case errors.Is(err, service.ErrUnauthenticated):
+			status = http.StatusUnauthorized
+		}
+		log.Printf("CheckRateLimit: Client not identified: %v, status code: %d", err, status)
+		http.Error(w, err.Error(), status)
 		return
 	}
+	clientID := identity.ClientID
+	if clientID == "" {
+		clientID = "anonymous"
+	}
 
-	allowed := con.userSevice.Allow(clientID)
-	if allowed {
-		log.Printf("CheckRateLimit: Request allowed for client_id: %s", clientID)
-		con.LBcontroller.BalanceRequest(w, r)
+	cost := con.userSevice.RequestCost(r)
+	decision, known := con.userSevice.Allow(identity, r, cost)
+	if !known {
+		status := con.userSevice.UnknownStatus
+		log.Printf("CheckRateLimit: Unknown client_id: %s, status code: %d, duration: %v", clientID, status, time.Since(startTime))
+		http.Error(w, "Unknown client", status)
+		return
+	}
+	writeRateLimitHeaders(w.Header(), decision)
+	if decision.Allowed {
+		log.Printf("CheckRateLimit: Request allowed for client_id: %s, cost: %v", clientID, cost)
+		cw := newCostReportingWriter(w, con.userSevice.Costs.ResponseHeader())
+		con.LBcontroller.BalanceRequest(cw, r)
+		con.userSevice.SettleCost(identity, r, cost, cw.Reported())
 		log.Printf("CheckRateLimit: Request balanced, status code: %d, duration: %v", http.StatusOK, time.Since(startTime))
 	} else {
-		log.Printf("CheckRateLimit: Rate limit exceeded for client_id: %s", clientID)
+		reason := "Rate limit exceeded"
+		if decision.QuotaExceeded {
+			reason = "Quota exceeded"
+		}
+		log.Printf("CheckRateLimit: %s for client_id: %s", reason, clientID)
+		w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(decision.RetryAfter), 10))
 		w.WriteHeader(http.StatusTooManyRequests)
-		fmt.Fprintln(w, "Rate limit exceeded")
+		fmt.Fprintln(w, reason)
 		log.Printf("CheckRateLimit: Rate limit exceeded, status code: %d, duration: %v", http.StatusTooManyRequests, time.Since(startTime))
 	}
 }
+
+// writeRateLimitHeaders sets the RateLimit-* fields from the IETF
+// draft-ietf-httpapi-ratelimit-headers, with Reset given in seconds.
+func writeRateLimitHeaders(h http.Header, decision model.Decision) {
+	h.Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	h.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(decision.ResetAfter), 10))
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(d.Seconds()))
+}
@@ -30,5 +30,153 @@ func Migrate(db *sql.DB) error {
 	if err != nil {
 		return fmt.Errorf("failed to create table: %w", err)
 	}
+
+	_, err = db.Exec(`
+        ALTER TABLE clients ADD COLUMN IF NOT EXISTS algorithm TEXT NOT NULL DEFAULT 'token_bucket';
+        ALTER TABLE clients ADD COLUMN IF NOT EXISTS window_sec DOUBLE PRECISION NOT NULL DEFAULT 0;
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to add limiter algorithm columns: %w", err)
+	}
+
+	_, err = db.Exec(`
+        ALTER TABLE clients ADD COLUMN IF NOT EXISTS tokens DOUBLE PRECISION;
+        ALTER TABLE clients ADD COLUMN IF NOT EXISTS last_refill TIMESTAMPTZ;
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to add limiter state columns: %w", err)
+	}
+
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS route_policies (
+            id BIGSERIAL PRIMARY KEY,
+            client_id TEXT NOT NULL REFERENCES clients (client_id) ON DELETE CASCADE,
+            method TEXT NOT NULL DEFAULT '',
+            path TEXT NOT NULL DEFAULT '',
+            capacity INTEGER NOT NULL,
+            rate_per_sec DOUBLE PRECISION NOT NULL,
+            algorithm TEXT NOT NULL DEFAULT 'token_bucket',
+            window_sec DOUBLE PRECISION NOT NULL DEFAULT 0
+        );
+        CREATE INDEX IF NOT EXISTS route_policies_client_id_idx ON route_policies (client_id);
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create route policies table: %w", err)
+	}
+
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS organizations (
+            org_id TEXT PRIMARY KEY,
+            capacity INTEGER NOT NULL,
+            rate_per_sec DOUBLE PRECISION NOT NULL,
+            algorithm TEXT NOT NULL DEFAULT 'token_bucket',
+            window_sec DOUBLE PRECISION NOT NULL DEFAULT 0,
+            tokens DOUBLE PRECISION,
+            last_refill TIMESTAMPTZ
+        );
+        ALTER TABLE clients ADD COLUMN IF NOT EXISTS org_id TEXT REFERENCES organizations (org_id) ON DELETE SET NULL;
+        CREATE INDEX IF NOT EXISTS clients_org_id_idx ON clients (org_id);
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create organizations table: %w", err)
+	}
+
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS quotas (
+            id BIGSERIAL PRIMARY KEY,
+            client_id TEXT NOT NULL REFERENCES clients (client_id) ON DELETE CASCADE,
+            period TEXT NOT NULL,
+            quota DOUBLE PRECISION NOT NULL,
+            timezone TEXT NOT NULL DEFAULT '',
+            rolling BOOLEAN NOT NULL DEFAULT FALSE
+        );
+        CREATE TABLE IF NOT EXISTS quota_usage (
+            quota_id BIGINT NOT NULL REFERENCES quotas (id) ON DELETE CASCADE,
+            bucket_start TIMESTAMPTZ NOT NULL,
+            used DOUBLE PRECISION NOT NULL,
+            PRIMARY KEY (quota_id, bucket_start)
+        );
+        CREATE INDEX IF NOT EXISTS quota_usage_bucket_start_idx ON quota_usage (bucket_start);
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create quota tables: %w", err)
+	}
+
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS api_keys (
+            id BIGSERIAL PRIMARY KEY,
+            client_id TEXT NOT NULL REFERENCES clients (client_id) ON DELETE CASCADE,
+            key_hash TEXT NOT NULL UNIQUE,
+            prefix TEXT NOT NULL,
+            created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+            expires_at TIMESTAMPTZ,
+            revoked_at TIMESTAMPTZ
+        );
+        CREATE INDEX IF NOT EXISTS api_keys_client_id_idx ON api_keys (client_id);
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create API keys table: %w", err)
+	}
+
+	// Settings of clients on a plan are NULL unless the client overrides
+	// them; quotas and route policies belong to either a client or a plan.
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS plans (
+            plan_id TEXT PRIMARY KEY,
+            capacity INTEGER NOT NULL,
+            rate_per_sec DOUBLE PRECISION NOT NULL,
+            algorithm TEXT NOT NULL DEFAULT 'token_bucket',
+            window_sec DOUBLE PRECISION NOT NULL DEFAULT 0
+        );
+        ALTER TABLE clients ADD COLUMN IF NOT EXISTS plan_id TEXT REFERENCES plans (plan_id);
+        CREATE INDEX IF NOT EXISTS clients_plan_id_idx ON clients (plan_id);
+        ALTER TABLE clients
+            ALTER COLUMN capacity DROP NOT NULL,
+            ALTER COLUMN rate_per_sec DROP NOT NULL,
+            ALTER COLUMN algorithm DROP NOT NULL,
+            ALTER COLUMN window_sec DROP NOT NULL;
+
+        ALTER TABLE route_policies ALTER COLUMN client_id DROP NOT NULL;
+        ALTER TABLE route_policies ADD COLUMN IF NOT EXISTS plan_id TEXT REFERENCES plans (plan_id) ON DELETE CASCADE;
+        CREATE INDEX IF NOT EXISTS route_policies_plan_id_idx ON route_policies (plan_id);
+
+        ALTER TABLE quotas ALTER COLUMN client_id DROP NOT NULL;
+        ALTER TABLE quotas ADD COLUMN IF NOT EXISTS plan_id TEXT REFERENCES plans (plan_id) ON DELETE CASCADE;
+        CREATE INDEX IF NOT EXISTS quotas_client_id_idx ON quotas (client_id);
+        CREATE INDEX IF NOT EXISTS quotas_plan_id_idx ON quotas (plan_id);
+
+        ALTER TABLE quota_usage ADD COLUMN IF NOT EXISTS client_id TEXT NOT NULL DEFAULT '';
+        UPDATE quota_usage u SET client_id = q.client_id
+            FROM quotas q WHERE u.client_id = '' AND q.id = u.quota_id AND q.client_id IS NOT NULL;
+        ALTER TABLE quota_usage DROP CONSTRAINT IF EXISTS quota_usage_pkey;
+        CREATE UNIQUE INDEX IF NOT EXISTS quota_usage_client_bucket_idx ON quota_usage (quota_id, client_id, bucket_start);
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create plans table: %w", err)
+	}
+
+	// The buckets a client's limit overrides use in place of its own, one
+	// per client and settings. override_id joins the three, buckets from
+	// before it existed are keyed by their last seen settings.
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS override_buckets (
+            override_id TEXT,
+            client_id TEXT NOT NULL REFERENCES clients (client_id) ON DELETE CASCADE,
+            capacity INTEGER NOT NULL,
+            rate_per_sec DOUBLE PRECISION NOT NULL,
+            tokens DOUBLE PRECISION,
+            last_refill TIMESTAMPTZ
+        );
+        ALTER TABLE override_buckets ADD COLUMN IF NOT EXISTS override_id TEXT;
+        UPDATE override_buckets SET override_id = client_id || '/' || capacity || '/' || rate_per_sec
+            WHERE override_id IS NULL;
+        ALTER TABLE override_buckets DROP CONSTRAINT IF EXISTS override_buckets_pkey;
+        ALTER TABLE override_buckets ALTER COLUMN override_id SET NOT NULL;
+        CREATE UNIQUE INDEX IF NOT EXISTS override_buckets_override_id_idx ON override_buckets (override_id);
+        CREATE INDEX IF NOT EXISTS override_buckets_client_id_idx ON override_buckets (client_id);
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create override buckets table: %w", err)
+	}
 	return nil
 }
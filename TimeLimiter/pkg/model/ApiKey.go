@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// ApiKey is the stored part of a client's API key. Only a SHA-256 hash of
+// the key is kept; Prefix is the beginning of the key, for telling keys apart.
+type ApiKey struct {
+	ID        int64      `json:"id"`
+	ClientID  string     `json:"client_id"`
+	Prefix    string     `json:"prefix"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+func (k ApiKey) Active(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// IssuedKey is returned once when a key is created; the plain key cannot be
+// recovered later.
+type IssuedKey struct {
+	ApiKey
+	Key string `json:"key"`
+}
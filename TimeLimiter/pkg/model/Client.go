@@ -1,8 +1,59 @@
 package model
 
+import (
+	"fmt"
+	"time"
+)
+
 type ClientConfig struct {
 	ClientID      string  `json:"client_id"`
 	Capacity      int     `json:"capacity"`
 	RatePerSec    float64 `json:"rate_per_sec"`
 	CurrentTokens float64 `json:"current_tokens"`
+	Algorithm     string  `json:"algorithm,omitempty"`
+	WindowSec     float64 `json:"window_sec,omitempty"`
+	OrgID         string  `json:"org_id,omitempty"`
+	// PlanID assigns the client to a plan; settings left at zero are then
+	// inherited from the plan.
+	PlanID string `json:"plan_id,omitempty"`
+}
+
+func (c ClientConfig) Validate() error {
+	if c.ClientID == "" {
+		return fmt.Errorf("client_id is required")
+	}
+	if c.Capacity < 0 {
+		return fmt.Errorf("capacity must not be negative")
+	}
+	if c.PlanID != "" {
+		// The effective settings are validated once the plan is applied.
+		if c.RatePerSec < 0 || c.WindowSec < 0 {
+			return fmt.Errorf("rate_per_sec and window_sec must not be negative")
+		}
+		return nil
+	}
+	if c.AlgorithmOrDefault() == TokenBucketAlgorithm && c.RatePerSec < 0 {
+		return fmt.Errorf("rate_per_sec must not be negative")
+	}
+	return CheckLimiter(c)
+}
+
+// Window is the rolling window of the sliding window algorithms. When
+// window_sec is not set it is derived from the rate, so that capacity
+// requests are allowed per capacity/rate_per_sec seconds.
+func (c ClientConfig) Window() time.Duration {
+	if c.WindowSec > 0 {
+		return time.Duration(c.WindowSec * float64(time.Second))
+	}
+	if c.RatePerSec > 0 {
+		return time.Duration(float64(c.Capacity) / c.RatePerSec * float64(time.Second))
+	}
+	return 0
+}
+
+func (c ClientConfig) AlgorithmOrDefault() string {
+	if c.Algorithm == "" {
+		return TokenBucketAlgorithm
+	}
+	return c.Algorithm
 }
@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+type ClientFilter struct {
+	Prefix    string
+	Algorithm string
+	OrgID     string
+	PlanID    string
+	Limit     int
+	Offset    int
+}
+
+type ClientUsage struct {
+	Available  float64   `json:"available"`
+	LastRefill time.Time `json:"last_refill"`
+	// OverrideAvailable is the balance of the bucket used instead of the
+	// client's own one while its requests carry a limit override.
+	OverrideAvailable *float64 `json:"override_available,omitempty"`
+	AllowedLastMinute float64  `json:"allowed_last_minute"`
+	DeniedLastMinute  float64  `json:"denied_last_minute"`
+}
+
+type ClientStatus struct {
+	ClientConfig
+	// Effective is the config in force once the client's plan is applied,
+	// it is only set for clients on a plan.
+	Effective *ClientConfig `json:"effective,omitempty"`
+	Usage     *ClientUsage  `json:"usage,omitempty"`
+}
+
+type ClientPage struct {
+	Clients []ClientStatus `json:"clients"`
+	Total   int            `json:"total"`
+	Limit   int            `json:"limit"`
+	Offset  int            `json:"offset"`
+}
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"path"
+	"strings"
+)
+
+// RouteMatcher matches a request by method and path pattern. Empty fields
+// match anything, the path uses path.Match syntax and a trailing "/**"
+// matches the whole subtree.
+type RouteMatcher struct {
+	Method string `json:"method,omitempty"`
+	Path   string `json:"path,omitempty"`
+}
+
+func (m RouteMatcher) Match(method string, urlPath string) bool {
+	if m.Method != "" && !strings.EqualFold(m.Method, method) {
+		return false
+	}
+	if m.Path == "" {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(m.Path, "/**"); ok {
+		return urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/")
+	}
+	matched, err := path.Match(m.Path, urlPath)
+	return err == nil && matched
+}
+
+type CostRule struct {
+	RouteMatcher
+	Header      string  `json:"header,omitempty"`
+	HeaderValue string  `json:"header_value,omitempty"`
+	Cost        float64 `json:"cost"`
+}
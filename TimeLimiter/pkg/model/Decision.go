@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+type Decision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAfter time.Duration
+	RetryAfter time.Duration
+	// QuotaExceeded is set when a long-period quota, not the rate limit,
+	// denied the request.
+	QuotaExceeded bool
+}
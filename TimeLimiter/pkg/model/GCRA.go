@@ -0,0 +1,149 @@
+package model
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+type gcraParams struct {
+	capacity int
+	// emission is the interval between two requests at the sustained rate.
+	emission int64
+}
+
+// GCRA is the Generic Cell Rate Algorithm. The whole per-client state is the
+// theoretical arrival time (TAT) of the next request, updated with
+// compare-and-swap, so Take never blocks. A request is conforming when it
+// does not push the TAT further than capacity emission intervals ahead.
+type GCRA struct {
+	tat    int64
+	params atomic.Pointer[gcraParams]
+}
+
+func NewGCRA(capacity int, ratePerSec float64) *GCRA {
+	g := &GCRA{}
+	g.params.Store(newGCRAParams(capacity, ratePerSec))
+	return g
+}
+
+func newGCRAParams(capacity int, ratePerSec float64) *gcraParams {
+	emission := int64(math.MaxInt64 / 4)
+	if ratePerSec > 0 {
+		emission = int64(float64(time.Second) / ratePerSec)
+	}
+	return &gcraParams{capacity: capacity, emission: emission}
+}
+
+func (g *GCRA) Algorithm() string {
+	return GCRAAlgorithm
+}
+
+func (g *GCRA) Take(n float64) Decision {
+	p := g.params.Load()
+	tau := p.emission * int64(p.capacity)
+	increment := int64(math.Ceil(n * float64(p.emission)))
+
+	for {
+		now := time.Now().UnixNano()
+		tat := atomic.LoadInt64(&g.tat)
+		start := tat
+		if start < now {
+			start = now
+		}
+		newTat := start + increment
+		allowAt := newTat - tau
+
+		if now < allowAt {
+			decision := g.decision(p, now, start, false)
+			if increment > tau {
+				decision.RetryAfter = decision.ResetAfter
+			} else {
+				decision.RetryAfter = time.Duration(allowAt - now)
+			}
+			return decision
+		}
+		if atomic.CompareAndSwapInt64(&g.tat, tat, newTat) {
+			return g.decision(p, now, newTat, true)
+		}
+	}
+}
+
+func (g *GCRA) Adjust(n float64) {
+	p := g.params.Load()
+	delta := int64(n * float64(p.emission))
+	for {
+		now := time.Now().UnixNano()
+		old := atomic.LoadInt64(&g.tat)
+		tat := old
+		if tat < now {
+			tat = now
+		}
+		newTat := tat + delta
+		if newTat < now {
+			newTat = now
+		}
+		if limit := now + 2*p.emission*int64(p.capacity); newTat > limit {
+			newTat = limit
+		}
+		if atomic.CompareAndSwapInt64(&g.tat, old, newTat) {
+			return
+		}
+	}
+}
+
+func (g *GCRA) decision(p *gcraParams, now int64, tat int64, allowed bool) Decision {
+	backlog := tat - now
+	if backlog < 0 {
+		backlog = 0
+	}
+	remaining := p.capacity - int(math.Ceil(float64(backlog)/float64(p.emission)))
+	if remaining < 0 {
+		remaining = 0
+	}
+	return Decision{
+		Allowed:    allowed,
+		Limit:      p.capacity,
+		Remaining:  remaining,
+		ResetAfter: time.Duration(backlog),
+	}
+}
+
+func (g *GCRA) Available() float64 {
+	p := g.params.Load()
+	backlog := atomic.LoadInt64(&g.tat) - time.Now().UnixNano()
+	if backlog <= 0 {
+		return float64(p.capacity)
+	}
+	return math.Max(0, float64(p.capacity)-float64(backlog)/float64(p.emission))
+}
+
+func (g *GCRA) State() LimiterState {
+	return LimiterState{Tokens: g.Available(), LastRefill: time.Now()}
+}
+
+func (g *GCRA) Restore(state LimiterState) {
+	p := g.params.Load()
+	used := math.Max(0, float64(p.capacity)-state.Tokens)
+	atomic.StoreInt64(&g.tat, state.LastRefill.UnixNano()+int64(used*float64(p.emission)))
+}
+
+func (g *GCRA) SetCapacity(capacity int) {
+	for {
+		old := g.params.Load()
+		updated := &gcraParams{capacity: capacity, emission: old.emission}
+		if g.params.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
+
+func (g *GCRA) SetRate(ratePerSec float64) {
+	for {
+		old := g.params.Load()
+		updated := newGCRAParams(old.capacity, ratePerSec)
+		if g.params.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package model
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGCRATake(t *testing.T) {
+	// A rate of one token per 1000s keeps the refill during a test run
+	// negligible.
+	const slow = 0.001
+
+	tests := []struct {
+		name     string
+		capacity int
+		rate     float64
+		costs    []float64
+		allowed  []bool
+	}{
+		{"burst up to capacity", 3, slow, []float64{1, 1, 1, 1}, []bool{true, true, true, false}},
+		{"fractional costs", 2, slow, []float64{0.5, 0.5, 0.5, 0.5, 0.5}, []bool{true, true, true, true, false}},
+		{"cost above capacity", 2, slow, []float64{3, 1}, []bool{false, true}},
+		{"denied request takes nothing", 2, slow, []float64{1.5, 1, 0.5}, []bool{true, false, true}},
+		{"zero capacity", 0, slow, []float64{1}, []bool{false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGCRA(tt.capacity, tt.rate)
+			for i, cost := range tt.costs {
+				if got := g.Take(cost).Allowed; got != tt.allowed[i] {
+					t.Fatalf("take %d of %v: allowed = %v, want %v", i, cost, got, tt.allowed[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGCRADecision(t *testing.T) {
+	tests := []struct {
+		name          string
+		capacity      int
+		rate          float64
+		taken         int
+		cost          float64
+		wantAllowed   bool
+		wantRemaining int
+		wantReset     time.Duration
+		wantRetry     time.Duration
+	}{
+		{"first request", 5, 1, 0, 1, true, 4, time.Second, 0},
+		{"half used", 4, 2, 2, 1, true, 1, 1500 * time.Millisecond, 0},
+		{"exhausted", 2, 1, 2, 1, false, 0, 2 * time.Second, time.Second},
+		{"exhausted by a larger cost", 2, 1, 1, 2, false, 1, time.Second, time.Second},
+		{"cost above capacity retries after reset", 2, 1, 1, 3, false, 1, time.Second, time.Second},
+	}
+	const tolerance = 50 * time.Millisecond
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGCRA(tt.capacity, tt.rate)
+			for i := 0; i < tt.taken; i++ {
+				g.Take(1)
+			}
+			d := g.Take(tt.cost)
+			if d.Allowed != tt.wantAllowed || d.Remaining != tt.wantRemaining || d.Limit != tt.capacity {
+				t.Fatalf("decision = %+v, want allowed %v, remaining %d, limit %d", d, tt.wantAllowed, tt.wantRemaining, tt.capacity)
+			}
+			if diff := d.ResetAfter - tt.wantReset; diff < -tolerance || diff > tolerance {
+				t.Errorf("ResetAfter = %v, want about %v", d.ResetAfter, tt.wantReset)
+			}
+			if diff := d.RetryAfter - tt.wantRetry; diff < -tolerance || diff > tolerance {
+				t.Errorf("RetryAfter = %v, want about %v", d.RetryAfter, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestGCRAAdjust(t *testing.T) {
+	tests := []struct {
+		name    string
+		taken   float64
+		adjust  float64
+		costs   []float64
+		allowed []bool
+	}{
+		{"refund frees tokens", 2, -1, []float64{1, 1}, []bool{true, false}},
+		{"refund never exceeds capacity", 1, -5, []float64{2, 1}, []bool{true, false}},
+		{"debit uses tokens", 0, 1.5, []float64{0.5, 0.5}, []bool{true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGCRA(2, 0.001)
+			if tt.taken > 0 {
+				g.Take(tt.taken)
+			}
+			g.Adjust(tt.adjust)
+			for i, cost := range tt.costs {
+				if got := g.Take(cost).Allowed; got != tt.allowed[i] {
+					t.Fatalf("take %d of %v: allowed = %v, want %v", i, cost, got, tt.allowed[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGCRAAdjustIsCapped(t *testing.T) {
+	// One token per millisecond: a debit of 100 tokens is capped at twice
+	// the capacity, so the bucket conforms again after a few milliseconds.
+	g := NewGCRA(2, 1000)
+	g.Adjust(100)
+	time.Sleep(20 * time.Millisecond)
+	if !g.Take(1).Allowed {
+		t.Fatal("debit was not capped")
+	}
+}
+
+func TestGCRARestore(t *testing.T) {
+	tests := []struct {
+		name  string
+		state LimiterState
+		want  float64
+	}{
+		{"empty bucket now", LimiterState{Tokens: 0, LastRefill: time.Now()}, 0},
+		{"partly used now", LimiterState{Tokens: 6, LastRefill: time.Now()}, 6},
+		{"refilled since", LimiterState{Tokens: 0, LastRefill: time.Now().Add(-4 * time.Second)}, 4},
+		{"full after a long pause", LimiterState{Tokens: 0, LastRefill: time.Now().Add(-time.Hour)}, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGCRA(10, 1)
+			g.Restore(tt.state)
+			if got := g.Available(); math.Abs(got-tt.want) > 0.1 {
+				t.Fatalf("Available() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGCRASetCapacity(t *testing.T) {
+	g := NewGCRA(1, 0.001)
+	g.Take(1)
+	if g.Take(1).Allowed {
+		t.Fatal("bucket should be empty")
+	}
+	g.SetCapacity(3)
+	if !g.Take(1).Allowed {
+		t.Fatal("a larger capacity should apply to the next request")
+	}
+}
@@ -0,0 +1,32 @@
+package model
+
+import (
+	"fmt"
+	"math"
+)
+
+// LimitOverride replaces a client's own bucket with a token bucket of the
+// given settings, e.g. limits carried in the caller's token.
+type LimitOverride struct {
+	Capacity   int
+	RatePerSec float64
+}
+
+// Config returns the bucket settings of the override. A missing capacity
+// allows a burst of one second of rate, a missing rate refills the whole
+// capacity every second.
+func (o LimitOverride) Config(clientID string) ClientConfig {
+	config := ClientConfig{
+		ClientID:   fmt.Sprintf("%s:override", clientID),
+		Capacity:   o.Capacity,
+		RatePerSec: o.RatePerSec,
+		Algorithm:  TokenBucketAlgorithm,
+	}
+	if config.Capacity <= 0 {
+		config.Capacity = int(math.Ceil(config.RatePerSec))
+	}
+	if config.RatePerSec <= 0 {
+		config.RatePerSec = float64(config.Capacity)
+	}
+	return config
+}
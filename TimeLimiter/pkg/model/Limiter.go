@@ -0,0 +1,86 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	TokenBucketAlgorithm          = "token_bucket"
+	SlidingWindowLogAlgorithm     = "sliding_window_log"
+	SlidingWindowCounterAlgorithm = "sliding_window_counter"
+	GCRAAlgorithm                 = "gcra"
+)
+
+type Limiter interface {
+	Take(n float64) Decision
+	// Adjust debits (positive n) or refunds (negative n) usage after the
+	// fact, e.g. when the backend reports the actual cost of a request.
+	Adjust(n float64)
+	Available() float64
+	SetCapacity(capacity int)
+	SetRate(ratePerSec float64)
+	Algorithm() string
+	State() LimiterState
+	Restore(state LimiterState)
+}
+
+// SharedLimiter is implemented by limiters whose state is owned by the
+// database rather than the process, it must not be overwritten by SaveState.
+type SharedLimiter interface {
+	Limiter
+	Shared()
+}
+
+// LimiterState is the live usage persisted across restarts. LastRefill is
+// the moment Tokens was accurate; limiters account for the time passed
+// since then when restoring.
+type LimiterState struct {
+	Tokens     float64
+	LastRefill time.Time
+}
+
+// WindowLimiter is implemented by the limiters that count requests over a
+// rolling window instead of refilling at a rate.
+type WindowLimiter interface {
+	Limiter
+	SetWindow(window time.Duration)
+}
+
+func NewLimiter(config ClientConfig) (Limiter, error) {
+	if err := CheckLimiter(config); err != nil {
+		return nil, err
+	}
+	switch config.Algorithm {
+	case "", TokenBucketAlgorithm:
+		return NewTokenBucket(config.ClientID, config.Capacity, config.RatePerSec), nil
+	case SlidingWindowLogAlgorithm:
+		return NewSlidingWindowLog(config.ClientID, config.Capacity, config.Window()), nil
+	case SlidingWindowCounterAlgorithm:
+		return NewSlidingWindowCounter(config.ClientID, config.Capacity, config.Window()), nil
+	case GCRAAlgorithm:
+		return NewGCRA(config.Capacity, config.RatePerSec), nil
+	default:
+		return nil, fmt.Errorf("unknown limiter algorithm %q", config.Algorithm)
+	}
+}
+
+// CheckLimiter reports settings a limiter cannot work with, such as a zero
+// window, which would divide by zero. Rows stored before validation
+// existed may still contain them.
+func CheckLimiter(config ClientConfig) error {
+	switch config.Algorithm {
+	case "", TokenBucketAlgorithm:
+	case SlidingWindowLogAlgorithm, SlidingWindowCounterAlgorithm:
+		if config.Window() <= 0 {
+			return fmt.Errorf("window_sec or rate_per_sec is required for algorithm %s", config.Algorithm)
+		}
+	case GCRAAlgorithm:
+		if config.RatePerSec <= 0 {
+			return fmt.Errorf("rate_per_sec must be positive for algorithm %s", config.Algorithm)
+		}
+	default:
+		return fmt.Errorf("unknown limiter algorithm %q", config.Algorithm)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package model
+
+import "fmt"
+
+// Organization groups clients under one pooled limit: a request of any of
+// its clients is also charged to the organization's bucket.
+type Organization struct {
+	OrgID         string  `json:"org_id"`
+	Capacity      int     `json:"capacity"`
+	RatePerSec    float64 `json:"rate_per_sec"`
+	CurrentTokens float64 `json:"current_tokens"`
+	Algorithm     string  `json:"algorithm,omitempty"`
+	WindowSec     float64 `json:"window_sec,omitempty"`
+}
+
+func (o Organization) Validate() error {
+	if o.OrgID == "" {
+		return fmt.Errorf("org_id is required")
+	}
+	return o.LimiterConfig().Validate()
+}
+
+// LimiterConfig describes the organization's bucket in the form the limiter
+// constructors take.
+func (o Organization) LimiterConfig() ClientConfig {
+	return ClientConfig{
+		ClientID:   "org:" + o.OrgID,
+		Capacity:   o.Capacity,
+		RatePerSec: o.RatePerSec,
+		Algorithm:  o.Algorithm,
+		WindowSec:  o.WindowSec,
+	}
+}
+
+func (o Organization) AlgorithmOrDefault() string {
+	return o.LimiterConfig().AlgorithmOrDefault()
+}
@@ -0,0 +1,46 @@
+package model
+
+import "fmt"
+
+// Plan holds the bucket settings shared by every client assigned to it. The
+// plan's quotas and route policies apply to each of its clients separately,
+// with their own usage.
+type Plan struct {
+	PlanID     string        `json:"plan_id"`
+	Capacity   int           `json:"capacity"`
+	RatePerSec float64       `json:"rate_per_sec"`
+	Algorithm  string        `json:"algorithm,omitempty"`
+	WindowSec  float64       `json:"window_sec,omitempty"`
+	Quotas     []Quota       `json:"quotas,omitempty"`
+	Policies   []RoutePolicy `json:"policies,omitempty"`
+}
+
+func (p Plan) Validate() error {
+	if p.PlanID == "" {
+		return fmt.Errorf("plan_id is required")
+	}
+	return p.Apply(ClientConfig{ClientID: p.PlanID}).Validate()
+}
+
+// Apply returns the client's settings with the ones the client does not
+// override taken from the plan.
+func (p Plan) Apply(c ClientConfig) ClientConfig {
+	if c.Capacity == 0 {
+		c.Capacity = p.Capacity
+	}
+	if c.RatePerSec == 0 {
+		c.RatePerSec = p.RatePerSec
+	}
+	if c.Algorithm == "" {
+		c.Algorithm = p.Algorithm
+	}
+	if c.WindowSec == 0 {
+		c.WindowSec = p.WindowSec
+	}
+	c.PlanID = ""
+	return c
+}
+
+func (p Plan) AlgorithmOrDefault() string {
+	return p.Apply(ClientConfig{}).AlgorithmOrDefault()
+}
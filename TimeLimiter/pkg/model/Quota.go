@@ -0,0 +1,91 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	QuotaDaily   = "daily"
+	QuotaMonthly = "monthly"
+)
+
+// rollingMonth is the length of a rolling monthly quota window.
+const rollingMonth = 30 * 24 * time.Hour
+
+// Quota caps the total cost a client may spend per day or month. Calendar
+// quotas reset at midnight or on the first of the month in Timezone; rolling
+// quotas count the last 24 hours or 30 days. Usage is kept in buckets of
+// one hour (rolling daily), one day (rolling monthly) or one period
+// (calendar), so a rolling window may count up to one extra bucket. Quotas
+// of a plan have PlanID set and count the usage of each client of the plan
+// separately.
+type Quota struct {
+	ID       int64   `json:"id"`
+	ClientID string  `json:"client_id,omitempty"`
+	PlanID   string  `json:"plan_id,omitempty"`
+	Period   string  `json:"period"`
+	Limit    float64 `json:"limit"`
+	Timezone string  `json:"timezone,omitempty"`
+	Rolling  bool    `json:"rolling"`
+}
+
+func (q Quota) Validate() error {
+	if q.Period != QuotaDaily && q.Period != QuotaMonthly {
+		return fmt.Errorf("period must be %s or %s", QuotaDaily, QuotaMonthly)
+	}
+	if q.Limit <= 0 {
+		return fmt.Errorf("limit must be positive")
+	}
+	if _, err := q.Location(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (q Quota) Location() (*time.Location, error) {
+	if q.Timezone == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(q.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", q.Timezone)
+	}
+	return loc, nil
+}
+
+// Window returns the bucket usage at t is counted in, the start of the
+// window the quota is enforced over and, for calendar quotas, when the
+// window resets.
+func (q Quota) Window(t time.Time, loc *time.Location) (bucket time.Time, since time.Time, resetAt time.Time) {
+	local := t.In(loc)
+	y, m, d := local.Date()
+	switch {
+	case q.Rolling && q.Period == QuotaDaily:
+		bucket = t.Truncate(time.Hour)
+		since = t.Add(-24 * time.Hour).Truncate(time.Hour)
+		return bucket, since, bucket.Add(time.Hour)
+	case q.Rolling:
+		bucket = time.Date(y, m, d, 0, 0, 0, 0, loc)
+		since = t.Add(-rollingMonth).In(loc)
+		sy, sm, sd := since.Date()
+		since = time.Date(sy, sm, sd, 0, 0, 0, 0, loc)
+		return bucket, since, bucket.AddDate(0, 0, 1)
+	case q.Period == QuotaDaily:
+		bucket = time.Date(y, m, d, 0, 0, 0, 0, loc)
+		return bucket, bucket, bucket.AddDate(0, 0, 1)
+	default:
+		bucket = time.Date(y, m, 1, 0, 0, 0, 0, loc)
+		return bucket, bucket, bucket.AddDate(0, 1, 0)
+	}
+}
+
+// QuotaUsage reports how much of a quota is spent in its current window.
+// For rolling quotas ResetsAt is when the next usage bucket starts.
+type QuotaUsage struct {
+	Quota
+	Used        float64   `json:"used"`
+	Remaining   float64   `json:"remaining"`
+	WindowStart time.Time `json:"window_start"`
+	ResetsAt    time.Time `json:"resets_at"`
+}
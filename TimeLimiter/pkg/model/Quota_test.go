@@ -0,0 +1,84 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuotaWindow(t *testing.T) {
+	utc := time.UTC
+	plus3 := time.FixedZone("UTC+3", 3*60*60)
+	at := func(loc *time.Location, y int, m time.Month, d, h, min int) time.Time {
+		return time.Date(y, m, d, h, min, 0, 0, loc)
+	}
+
+	tests := []struct {
+		name       string
+		quota      Quota
+		loc        *time.Location
+		t          time.Time
+		wantBucket time.Time
+		wantSince  time.Time
+		wantReset  time.Time
+	}{
+		{
+			"daily", Quota{Period: QuotaDaily}, utc, at(utc, 2024, 3, 15, 13, 45),
+			at(utc, 2024, 3, 15, 0, 0), at(utc, 2024, 3, 15, 0, 0), at(utc, 2024, 3, 16, 0, 0),
+		},
+		{
+			"daily in the quota's timezone", Quota{Period: QuotaDaily}, plus3, at(utc, 2024, 3, 15, 22, 30),
+			at(plus3, 2024, 3, 16, 0, 0), at(plus3, 2024, 3, 16, 0, 0), at(plus3, 2024, 3, 17, 0, 0),
+		},
+		{
+			"monthly", Quota{Period: QuotaMonthly}, utc, at(utc, 2024, 1, 31, 10, 0),
+			at(utc, 2024, 1, 1, 0, 0), at(utc, 2024, 1, 1, 0, 0), at(utc, 2024, 2, 1, 0, 0),
+		},
+		{
+			"monthly across the year end", Quota{Period: QuotaMonthly}, utc, at(utc, 2024, 12, 31, 23, 59),
+			at(utc, 2024, 12, 1, 0, 0), at(utc, 2024, 12, 1, 0, 0), at(utc, 2025, 1, 1, 0, 0),
+		},
+		{
+			"rolling daily", Quota{Period: QuotaDaily, Rolling: true}, utc, at(utc, 2024, 3, 15, 13, 45),
+			at(utc, 2024, 3, 15, 13, 0), at(utc, 2024, 3, 14, 13, 0), at(utc, 2024, 3, 15, 14, 0),
+		},
+		{
+			"rolling monthly", Quota{Period: QuotaMonthly, Rolling: true}, utc, at(utc, 2024, 3, 15, 13, 45),
+			at(utc, 2024, 3, 15, 0, 0), at(utc, 2024, 2, 14, 0, 0), at(utc, 2024, 3, 16, 0, 0),
+		},
+		{
+			"rolling monthly in the quota's timezone", Quota{Period: QuotaMonthly, Rolling: true}, plus3, at(utc, 2024, 3, 15, 22, 30),
+			at(plus3, 2024, 3, 16, 0, 0), at(plus3, 2024, 2, 15, 0, 0), at(plus3, 2024, 3, 17, 0, 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket, since, reset := tt.quota.Window(tt.t, tt.loc)
+			if !bucket.Equal(tt.wantBucket) || !since.Equal(tt.wantSince) || !reset.Equal(tt.wantReset) {
+				t.Fatalf("Window(%v) = %v, %v, %v; want %v, %v, %v",
+					tt.t, bucket, since, reset, tt.wantBucket, tt.wantSince, tt.wantReset)
+			}
+		})
+	}
+}
+
+func TestQuotaValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		quota   Quota
+		wantErr bool
+	}{
+		{"daily", Quota{Period: QuotaDaily, Limit: 100}, false},
+		{"monthly in a timezone", Quota{Period: QuotaMonthly, Limit: 1, Timezone: "UTC"}, false},
+		{"unknown period", Quota{Period: "weekly", Limit: 100}, true},
+		{"zero limit", Quota{Period: QuotaDaily}, true},
+		{"negative limit", Quota{Period: QuotaDaily, Limit: -1}, true},
+		{"unknown timezone", Quota{Period: QuotaDaily, Limit: 1, Timezone: "Mars/Olympus_Mons"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.quota.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
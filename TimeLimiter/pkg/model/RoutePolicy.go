@@ -0,0 +1,59 @@
+package model
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// RoutePolicy is an additional limit a client has on the routes matched by
+// its RouteMatcher, on top of the client's own bucket. Policies of a plan
+// have PlanID set; loaded for a client, ClientID is that client and each
+// client of the plan has its own bucket.
+type RoutePolicy struct {
+	ID       int64  `json:"id"`
+	ClientID string `json:"client_id,omitempty"`
+	PlanID   string `json:"plan_id,omitempty"`
+	RouteMatcher
+	Capacity   int     `json:"capacity"`
+	RatePerSec float64 `json:"rate_per_sec"`
+	Algorithm  string  `json:"algorithm,omitempty"`
+	WindowSec  float64 `json:"window_sec,omitempty"`
+}
+
+func (p RoutePolicy) Validate() error {
+	if p.Method == "" && p.Path == "" {
+		return fmt.Errorf("method or path is required")
+	}
+	if p.Path != "" {
+		if !strings.HasPrefix(p.Path, "/") {
+			return fmt.Errorf("path must start with /")
+		}
+		if _, err := path.Match(strings.TrimSuffix(p.Path, "/**"), ""); err != nil {
+			return fmt.Errorf("invalid path pattern %q: %w", p.Path, err)
+		}
+	}
+	return p.LimiterConfig().Validate()
+}
+
+// LimiterConfig describes the policy's bucket in the form the limiter
+// constructors take.
+func (p RoutePolicy) LimiterConfig() ClientConfig {
+	return ClientConfig{
+		ClientID:   fmt.Sprintf("%s:policy:%d", p.ClientID, p.ID),
+		Capacity:   p.Capacity,
+		RatePerSec: p.RatePerSec,
+		Algorithm:  p.Algorithm,
+		WindowSec:  p.WindowSec,
+	}
+}
+
+func (p RoutePolicy) AlgorithmOrDefault() string {
+	return p.LimiterConfig().AlgorithmOrDefault()
+}
+
+// PolicyLimiter is a route policy together with its live limiter.
+type PolicyLimiter struct {
+	Policy  RoutePolicy
+	Limiter Limiter
+}
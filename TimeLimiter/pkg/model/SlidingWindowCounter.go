@@ -0,0 +1,161 @@
+package model
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// SlidingWindowCounter approximates a sliding window with two fixed window
+// counters: the previous window's count is weighted by how much of it still
+// overlaps the rolling window. It needs constant memory per client.
+type SlidingWindowCounter struct {
+	capacity    int
+	window      time.Duration
+	windowStart time.Time
+	previous    float64
+	current     float64
+	client_id   string
+	updateMutex sync.Mutex
+}
+
+func NewSlidingWindowCounter(clientID string, capacity int, window time.Duration) *SlidingWindowCounter {
+	return &SlidingWindowCounter{
+		capacity:    capacity,
+		window:      window,
+		windowStart: time.Now(),
+		client_id:   clientID,
+	}
+}
+
+func (sc *SlidingWindowCounter) Algorithm() string {
+	return SlidingWindowCounterAlgorithm
+}
+
+func (sc *SlidingWindowCounter) Available() float64 {
+	sc.updateMutex.Lock()
+	defer sc.updateMutex.Unlock()
+
+	now := time.Now()
+	sc.advance(now)
+	return math.Max(0, float64(sc.capacity)-sc.estimate(now))
+}
+
+func (sc *SlidingWindowCounter) Take(n float64) Decision {
+	sc.updateMutex.Lock()
+	defer sc.updateMutex.Unlock()
+
+	now := time.Now()
+	sc.advance(now)
+
+	allowed := sc.estimate(now)+n <= float64(sc.capacity)
+	if allowed {
+		sc.current += n
+	}
+
+	elapsed := now.Sub(sc.windowStart)
+	decision := Decision{
+		Allowed:   allowed,
+		Limit:     sc.capacity,
+		Remaining: int(math.Max(0, math.Floor(float64(sc.capacity)-sc.estimate(now)))),
+	}
+	switch {
+	case sc.current > 0:
+		decision.ResetAfter = 2*sc.window - elapsed
+	case sc.previous > 0:
+		decision.ResetAfter = sc.window - elapsed
+	}
+	if !allowed {
+		decision.RetryAfter = sc.retryAfter(n, elapsed)
+	}
+	return decision
+}
+
+func (sc *SlidingWindowCounter) Adjust(n float64) {
+	sc.updateMutex.Lock()
+	defer sc.updateMutex.Unlock()
+
+	sc.advance(time.Now())
+	sc.current = math.Max(0, sc.current+n)
+}
+
+func (sc *SlidingWindowCounter) State() LimiterState {
+	sc.updateMutex.Lock()
+	defer sc.updateMutex.Unlock()
+
+	now := time.Now()
+	sc.advance(now)
+	return LimiterState{Tokens: float64(sc.capacity) - sc.estimate(now), LastRefill: now}
+}
+
+// Restore places the whole persisted usage in a window starting at
+// LastRefill, advance then ages it out according to the downtime.
+func (sc *SlidingWindowCounter) Restore(state LimiterState) {
+	sc.updateMutex.Lock()
+	defer sc.updateMutex.Unlock()
+
+	sc.previous = 0
+	sc.current = math.Max(0, float64(sc.capacity)-state.Tokens)
+	sc.windowStart = state.LastRefill
+	if sc.windowStart.After(time.Now()) {
+		sc.windowStart = time.Now()
+	}
+	sc.advance(time.Now())
+}
+
+// advance rolls the fixed windows forward so that windowStart is the start
+// of the window containing now.
+func (sc *SlidingWindowCounter) advance(now time.Time) {
+	elapsed := now.Sub(sc.windowStart)
+	if elapsed < sc.window {
+		return
+	}
+	if elapsed < 2*sc.window {
+		sc.previous = sc.current
+	} else {
+		sc.previous = 0
+	}
+	sc.current = 0
+	sc.windowStart = sc.windowStart.Add(elapsed / sc.window * sc.window)
+}
+
+func (sc *SlidingWindowCounter) estimate(now time.Time) float64 {
+	overlap := 1 - float64(now.Sub(sc.windowStart))/float64(sc.window)
+	return sc.previous*overlap + sc.current
+}
+
+func (sc *SlidingWindowCounter) retryAfter(n float64, elapsed time.Duration) time.Duration {
+	capacity := float64(sc.capacity)
+	if n > capacity {
+		return 2*sc.window - elapsed
+	}
+
+	window := float64(sc.window)
+	if sc.current+n <= capacity && sc.previous > 0 {
+		// Wait in the current window until enough of the previous one slid out.
+		wait := window*(1-(capacity-sc.current-n)/sc.previous) - float64(elapsed)
+		return time.Duration(math.Max(0, wait))
+	}
+
+	// Wait for the next window, where the current count becomes the weighted one.
+	wait := float64(sc.window - elapsed)
+	if sc.current > 0 {
+		wait += math.Max(0, window*(1-(capacity-n)/sc.current))
+	}
+	return time.Duration(wait)
+}
+
+func (sc *SlidingWindowCounter) SetCapacity(capacity int) {
+	sc.updateMutex.Lock()
+	defer sc.updateMutex.Unlock()
+	sc.capacity = capacity
+}
+
+// SetRate is a no-op, a window counter is defined by capacity and window only.
+func (sc *SlidingWindowCounter) SetRate(ratePerSec float64) {}
+
+func (sc *SlidingWindowCounter) SetWindow(window time.Duration) {
+	sc.updateMutex.Lock()
+	defer sc.updateMutex.Unlock()
+	sc.window = window
+}
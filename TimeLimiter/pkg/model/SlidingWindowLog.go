@@ -0,0 +1,153 @@
+package model
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// SlidingWindowLog remembers the time of every accepted request and allows
+// at most capacity of them within any rolling window. It is exact, at the
+// price of one timestamp per allowed request.
+type SlidingWindowLog struct {
+	capacity    int
+	window      time.Duration
+	log         []time.Time
+	client_id   string
+	updateMutex sync.Mutex
+}
+
+func NewSlidingWindowLog(clientID string, capacity int, window time.Duration) *SlidingWindowLog {
+	return &SlidingWindowLog{
+		capacity:  capacity,
+		window:    window,
+		log:       make([]time.Time, 0, capacity),
+		client_id: clientID,
+	}
+}
+
+func (sw *SlidingWindowLog) Algorithm() string {
+	return SlidingWindowLogAlgorithm
+}
+
+func (sw *SlidingWindowLog) Available() float64 {
+	sw.updateMutex.Lock()
+	defer sw.updateMutex.Unlock()
+
+	sw.evict(time.Now())
+	return float64(sw.capacity - len(sw.log))
+}
+
+func (sw *SlidingWindowLog) Take(n float64) Decision {
+	sw.updateMutex.Lock()
+	defer sw.updateMutex.Unlock()
+
+	now := time.Now()
+	sw.evict(now)
+
+	cost := int(math.Ceil(n))
+	allowed := len(sw.log)+cost <= sw.capacity
+	if allowed {
+		for i := 0; i < cost; i++ {
+			sw.log = append(sw.log, now)
+		}
+	}
+
+	decision := Decision{
+		Allowed:   allowed,
+		Limit:     sw.capacity,
+		Remaining: sw.capacity - len(sw.log),
+	}
+	if len(sw.log) > 0 {
+		decision.ResetAfter = sw.log[len(sw.log)-1].Add(sw.window).Sub(now)
+	}
+	if !allowed {
+		if cost > sw.capacity {
+			decision.RetryAfter = decision.ResetAfter
+		} else {
+			// The request fits once enough of the oldest entries expire.
+			oldest := sw.log[len(sw.log)+cost-sw.capacity-1]
+			decision.RetryAfter = oldest.Add(sw.window).Sub(now)
+		}
+	}
+	return decision
+}
+
+func (sw *SlidingWindowLog) Adjust(n float64) {
+	sw.updateMutex.Lock()
+	defer sw.updateMutex.Unlock()
+
+	now := time.Now()
+	sw.evict(now)
+	if n >= 0 {
+		for i := 0; i < int(math.Ceil(n)); i++ {
+			sw.log = append(sw.log, now)
+		}
+		return
+	}
+
+	// Refunds drop the most recent entries, those belong to the request
+	// being refunded. They round up like Take so that refunding a denied
+	// request's cost returns every entry it added.
+	refund := int(math.Ceil(-n))
+	if refund > len(sw.log) {
+		refund = len(sw.log)
+	}
+	sw.log = sw.log[:len(sw.log)-refund]
+}
+
+// State only keeps the number of requests in the window and the newest
+// timestamp, on restore they are all assumed to have happened at that time.
+func (sw *SlidingWindowLog) State() LimiterState {
+	sw.updateMutex.Lock()
+	defer sw.updateMutex.Unlock()
+
+	now := time.Now()
+	sw.evict(now)
+	state := LimiterState{Tokens: float64(sw.capacity - len(sw.log)), LastRefill: now}
+	if len(sw.log) > 0 {
+		state.LastRefill = sw.log[len(sw.log)-1]
+	}
+	return state
+}
+
+func (sw *SlidingWindowLog) Restore(state LimiterState) {
+	sw.updateMutex.Lock()
+	defer sw.updateMutex.Unlock()
+
+	used := sw.capacity - int(math.Ceil(state.Tokens))
+	sw.log = sw.log[:0]
+	for i := 0; i < used; i++ {
+		sw.log = append(sw.log, state.LastRefill)
+	}
+	sw.evict(time.Now())
+}
+
+func (sw *SlidingWindowLog) evict(now time.Time) {
+	cutoff := now.Add(-sw.window)
+	i := 0
+	for i < len(sw.log) && !sw.log[i].After(cutoff) {
+		i++
+	}
+	if i > 0 {
+		sw.log = append(sw.log[:0], sw.log[i:]...)
+	}
+}
+
+func (sw *SlidingWindowLog) SetCapacity(capacity int) {
+	sw.updateMutex.Lock()
+	defer sw.updateMutex.Unlock()
+	sw.capacity = capacity
+	if len(sw.log) > capacity {
+		sw.log = append(sw.log[:0], sw.log[len(sw.log)-capacity:]...)
+	}
+}
+
+// SetRate is a no-op, a window log is defined by capacity and window only.
+func (sw *SlidingWindowLog) SetRate(ratePerSec float64) {}
+
+func (sw *SlidingWindowLog) SetWindow(window time.Duration) {
+	sw.updateMutex.Lock()
+	defer sw.updateMutex.Unlock()
+	sw.window = window
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"math"
 	"sync"
 	"time"
 )
@@ -14,6 +15,10 @@ type TokenBucket struct {
 	updateMutex sync.Mutex
 }
 
+func (tb *TokenBucket) Algorithm() string {
+	return TokenBucketAlgorithm
+}
+
 func (tb *TokenBucket) Available() float64 {
 	tb.updateMutex.Lock()
 	defer tb.updateMutex.Unlock()
@@ -23,18 +28,80 @@ func (tb *TokenBucket) Available() float64 {
 	return tb.tokens
 }
 
-func (tb *TokenBucket) Take(n float64) bool {
+func (tb *TokenBucket) Take(n float64) Decision {
 	tb.updateMutex.Lock()
 	defer tb.updateMutex.Unlock()
 
 	now := time.Now()
 	tb.refillTokens(now)
 
-	if tb.tokens >= n {
+	allowed := tb.tokens >= n
+	if allowed {
 		tb.tokens -= n
-		return true
 	}
-	return false
+	decision := Decision{
+		Allowed:    allowed,
+		Limit:      tb.capacity,
+		Remaining:  int(math.Floor(tb.tokens)),
+		ResetAfter: tb.timeToAccumulate(float64(tb.capacity)),
+	}
+	if !allowed {
+		if n > float64(tb.capacity) {
+			// The request can never fit into this bucket, waiting will not help.
+			decision.RetryAfter = decision.ResetAfter
+		} else {
+			decision.RetryAfter = tb.timeToAccumulate(n)
+		}
+	}
+	return decision
+}
+
+// Adjust may leave the bucket negative: a client that under-declared a
+// request's cost pays the debt back from future refills.
+func (tb *TokenBucket) Adjust(n float64) {
+	tb.updateMutex.Lock()
+	defer tb.updateMutex.Unlock()
+
+	tb.refillTokens(time.Now())
+	tb.tokens -= n
+	if tb.tokens > float64(tb.capacity) {
+		tb.tokens = float64(tb.capacity)
+	}
+	if tb.tokens < -float64(tb.capacity) {
+		tb.tokens = -float64(tb.capacity)
+	}
+}
+
+func (tb *TokenBucket) State() LimiterState {
+	tb.updateMutex.Lock()
+	defer tb.updateMutex.Unlock()
+	return LimiterState{Tokens: tb.tokens, LastRefill: tb.lastRefill}
+}
+
+func (tb *TokenBucket) Restore(state LimiterState) {
+	tb.updateMutex.Lock()
+	defer tb.updateMutex.Unlock()
+
+	tb.tokens = math.Min(state.Tokens, float64(tb.capacity))
+	tb.lastRefill = state.LastRefill
+	if tb.lastRefill.After(time.Now()) {
+		tb.lastRefill = time.Now()
+	}
+	// Credit the tokens accumulated while the state was not live.
+	tb.refillTokens(time.Now())
+}
+
+// timeToAccumulate reports how long until the bucket holds at least target
+// tokens at the current rate.
+func (tb *TokenBucket) timeToAccumulate(target float64) time.Duration {
+	deficit := target - tb.tokens
+	if deficit <= 0 {
+		return 0
+	}
+	if tb.ratePerSec <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(deficit / tb.ratePerSec * float64(time.Second))
 }
 
 func (tb *TokenBucket) refillTokens(now time.Time) {
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"LoadBalancer/TimeLimiter/pkg/model"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const apiKeyColumns = "id, client_id, prefix, created_at, expires_at, revoked_at"
+
+type ApiKeyRepo interface {
+	CreateKey(clientID string, hash string, prefix string, expiresAt *time.Time) (model.ApiKey, error)
+	RotateKey(clientID string, hash string, prefix string, expiresAt *time.Time, overlapUntil time.Time) (model.ApiKey, error)
+	ListKeys(clientID string) ([]model.ApiKey, error)
+	FindKey(hash string) (model.ApiKey, bool, error)
+	RevokeKey(clientID string, keyID int64) error
+}
+
+type ApiKeyRepoImpl struct {
+	db *sql.DB
+}
+
+func NewApiKeyRepoImpl(db *sql.DB) *ApiKeyRepoImpl {
+	return &ApiKeyRepoImpl{
+		db: db,
+	}
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanApiKey(row rowScanner) (model.ApiKey, error) {
+	var key model.ApiKey
+	var expiresAt, revokedAt sql.NullTime
+	if err := row.Scan(&key.ID, &key.ClientID, &key.Prefix, &key.CreatedAt, &expiresAt, &revokedAt); err != nil {
+		return key, err
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return key, nil
+}
+
+func (r *ApiKeyRepoImpl) CreateKey(clientID string, hash string, prefix string, expiresAt *time.Time) (model.ApiKey, error) {
+	key, err := insertKey(r.db, clientID, hash, prefix, expiresAt)
+	if err != nil {
+		return key, err
+	}
+	log.Printf("API key %d issued for client with ID %s", key.ID, clientID)
+	return key, nil
+}
+
+// RotateKey issues a new key and limits the validity of the client's other
+// active keys to overlapUntil, so callers have until then to switch.
+func (r *ApiKeyRepoImpl) RotateKey(clientID string, hash string, prefix string, expiresAt *time.Time, overlapUntil time.Time) (model.ApiKey, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return model.ApiKey{}, fmt.Errorf("failed to begin key rotation: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+        UPDATE api_keys SET expires_at = LEAST(COALESCE(expires_at, $2), $2)
+        WHERE client_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())`,
+		clientID, overlapUntil,
+	)
+	if err != nil {
+		return model.ApiKey{}, fmt.Errorf("failed to expire old keys: %w", err)
+	}
+
+	key, err := insertKey(tx, clientID, hash, prefix, expiresAt)
+	if err != nil {
+		return key, err
+	}
+	if err := tx.Commit(); err != nil {
+		return key, fmt.Errorf("failed to commit key rotation: %w", err)
+	}
+	log.Printf("API keys of client with ID %s rotated, old keys valid until %s", clientID, overlapUntil.Format(time.RFC3339))
+	return key, nil
+}
+
+func insertKey(db interface {
+	QueryRow(query string, args ...any) *sql.Row
+}, clientID string, hash string, prefix string, expiresAt *time.Time) (model.ApiKey, error) {
+	key, err := scanApiKey(db.QueryRow(
+		"INSERT INTO api_keys (client_id, key_hash, prefix, expires_at) VALUES ($1, $2, $3, $4) RETURNING "+apiKeyColumns,
+		clientID, hash, prefix, expiresAt,
+	))
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation {
+		return key, fmt.Errorf("client with ID %s: %w", clientID, ErrNotFound)
+	}
+	if err != nil {
+		return key, fmt.Errorf("failed to insert API key: %w", err)
+	}
+	return key, nil
+}
+
+func (r *ApiKeyRepoImpl) ListKeys(clientID string) ([]model.ApiKey, error) {
+	rows, err := r.db.Query("SELECT "+apiKeyColumns+" FROM api_keys WHERE client_id = $1 ORDER BY id", clientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []model.ApiKey{}
+	for rows.Next() {
+		key, err := scanApiKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return keys, nil
+}
+
+func (r *ApiKeyRepoImpl) FindKey(hash string) (model.ApiKey, bool, error) {
+	key, err := scanApiKey(r.db.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = $1", hash))
+	if err == sql.ErrNoRows {
+		return key, false, nil
+	}
+	if err != nil {
+		return key, false, fmt.Errorf("failed to look up API key: %w", err)
+	}
+	return key, true, nil
+}
+
+func (r *ApiKeyRepoImpl) RevokeKey(clientID string, keyID int64) error {
+	res, err := r.db.Exec(
+		"UPDATE api_keys SET revoked_at = COALESCE(revoked_at, now()) WHERE id = $1 AND client_id = $2",
+		keyID, clientID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("API key %d of client %s: %w", keyID, clientID, ErrNotFound)
+	}
+	log.Printf("API key %d of client with ID %s revoked", keyID, clientID)
+	return nil
+}
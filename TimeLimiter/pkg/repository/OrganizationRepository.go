@@ -0,0 +1,304 @@
+package repository
+
+import (
+	"LoadBalancer/TimeLimiter/pkg/model"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+const orgColumns = "org_id, capacity, rate_per_sec, algorithm, window_sec"
+
+// GetOrgBucket returns the bucket of the organization the client belongs
+// to, or nil if the client has none.
+func (r *UserRepoImpl) GetOrgBucket(clientID string) model.Limiter {
+	r.bucketsMutex.RLock()
+	defer r.bucketsMutex.RUnlock()
+	orgID, ok := r.parents[clientID]
+	if !ok {
+		return nil
+	}
+	return r.Orgs[orgID]
+}
+
+func (r *UserRepoImpl) GetOrgBuckets() map[string]model.Limiter {
+	r.bucketsMutex.RLock()
+	defer r.bucketsMutex.RUnlock()
+	return r.Orgs
+}
+
+func (r *UserRepoImpl) ListOrgs() ([]model.Organization, error) {
+	rows, err := r.db.Query("SELECT " + orgColumns + " FROM organizations ORDER BY org_id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query organizations: %w", err)
+	}
+	defer rows.Close()
+
+	orgs := []model.Organization{}
+	for rows.Next() {
+		var org model.Organization
+		if err := rows.Scan(&org.OrgID, &org.Capacity, &org.RatePerSec, &org.Algorithm, &org.WindowSec); err != nil {
+			return nil, fmt.Errorf("failed to scan organization: %w", err)
+		}
+		orgs = append(orgs, org)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return orgs, nil
+}
+
+func (r *UserRepoImpl) GetOrg(orgID string) (model.Organization, bool, error) {
+	var org model.Organization
+	err := r.db.QueryRow("SELECT "+orgColumns+" FROM organizations WHERE org_id = $1", orgID).
+		Scan(&org.OrgID, &org.Capacity, &org.RatePerSec, &org.Algorithm, &org.WindowSec)
+	if err == sql.ErrNoRows {
+		return org, false, nil
+	}
+	if err != nil {
+		return org, false, fmt.Errorf("failed to load organization %s: %w", orgID, err)
+	}
+	return org, true, nil
+}
+
+func (r *UserRepoImpl) AddOrg(org model.Organization) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	log.Printf("Attempting to add organization with ID %s", org.OrgID)
+	if _, ok := r.Orgs[org.OrgID]; ok {
+		return fmt.Errorf("organization with ID %s already exists", org.OrgID)
+	}
+
+	limiter, err := r.newLimiter(orgRef(org.OrgID), org.LimiterConfig())
+	if err != nil {
+		return err
+	}
+	var initialTokens sql.NullFloat64
+	if org.CurrentTokens > 0 {
+		limiter.Restore(model.LimiterState{Tokens: org.CurrentTokens, LastRefill: time.Now()})
+		initialTokens = sql.NullFloat64{Float64: org.CurrentTokens, Valid: true}
+	}
+
+	_, err = r.db.Exec(
+		`INSERT INTO organizations (org_id, capacity, rate_per_sec, algorithm, window_sec, tokens, last_refill)
+		VALUES ($1, $2, $3, $4, $5, $6::DOUBLE PRECISION, CASE WHEN $6 IS NULL THEN NULL ELSE now() END)`,
+		org.OrgID, org.Capacity, org.RatePerSec, org.AlgorithmOrDefault(), org.WindowSec, initialTokens,
+	)
+	if err != nil {
+		err = fmt.Errorf("failed to insert organization into DB: %w", err)
+		log.Printf("Failed to insert organization with ID %s into DB: %v", org.OrgID, err)
+		return err
+	}
+
+	r.setOrgBucket(org.OrgID, limiter)
+	r.notifyOrg(ClientUpserted, org.OrgID)
+	log.Printf("Organization with ID %s added successfully", org.OrgID)
+	return nil
+}
+
+func (r *UserRepoImpl) UpdateOrg(org model.Organization) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	log.Printf("Attempting to update organization with ID %s", org.OrgID)
+	res, err := r.db.Exec(
+		"UPDATE organizations SET capacity = $2, rate_per_sec = $3, algorithm = $4, window_sec = $5 WHERE org_id = $1",
+		org.OrgID, org.Capacity, org.RatePerSec, org.AlgorithmOrDefault(), org.WindowSec,
+	)
+	if err != nil {
+		err = fmt.Errorf("failed to update organization in DB: %w", err)
+		log.Printf("Failed to update organization with ID %s in DB: %v", org.OrgID, err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("organization with ID %s: %w", org.OrgID, ErrNotFound)
+	}
+
+	if err := r.applyOrg(org); err != nil {
+		return err
+	}
+	r.notifyOrg(ClientUpserted, org.OrgID)
+	log.Printf("Organization with ID %s updated successfully", org.OrgID)
+	return nil
+}
+
+// DeleteOrg removes the organization; its clients stay and lose the pooled
+// limit.
+func (r *UserRepoImpl) DeleteOrg(orgID string) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	log.Printf("Attempting to delete organization with ID %s", orgID)
+	res, err := r.db.Exec("DELETE FROM organizations WHERE org_id = $1", orgID)
+	if err != nil {
+		err = fmt.Errorf("failed to delete organization from DB: %w", err)
+		log.Printf("Failed to delete organization with ID %s from DB: %v", orgID, err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("organization with ID %s: %w", orgID, ErrNotFound)
+	}
+
+	r.removeOrg(orgID)
+	r.notifyOrg(ClientDeleted, orgID)
+	log.Printf("Organization with ID %s deleted successfully", orgID)
+	return nil
+}
+
+// applyOrg updates the organization's live limiter in place when the
+// algorithm is unchanged and replaces it otherwise. Must be called with
+// r.Mutex held.
+func (r *UserRepoImpl) applyOrg(org model.Organization) error {
+	config := org.LimiterConfig()
+	limiter, exists := r.Orgs[org.OrgID]
+	if exists && limiter.Algorithm() == config.AlgorithmOrDefault() {
+		return reconfigure(limiter, config)
+	}
+	limiter, err := r.newLimiter(orgRef(org.OrgID), config)
+	if err != nil {
+		return err
+	}
+	r.setOrgBucket(org.OrgID, limiter)
+	return nil
+}
+
+func orgRef(orgID string) BucketRef {
+	return BucketRef{Kind: OrgBucket, ID: orgID}
+}
+
+// loadOrgs builds the organization buckets, keeping the live limiters of
+// organizations already loaded. Like client buckets they are created
+// through the limiter factory, so the pooled limit is shared between
+// instances outside local mode. Must be called with r.Mutex held.
+func (r *UserRepoImpl) loadOrgs(restore bool) error {
+	query := "SELECT " + orgColumns + ", tokens, last_refill FROM organizations"
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return fmt.Errorf("failed to query organizations: %w", err)
+	}
+	defer rows.Close()
+
+	orgs := make(map[string]model.Limiter)
+	for rows.Next() {
+		var org model.Organization
+		var tokens sql.NullFloat64
+		var lastRefill sql.NullTime
+		if err := rows.Scan(&org.OrgID, &org.Capacity, &org.RatePerSec, &org.Algorithm, &org.WindowSec, &tokens, &lastRefill); err != nil {
+			return fmt.Errorf("failed to scan organization: %w", err)
+		}
+
+		config := org.LimiterConfig()
+		limiter, exists := r.Orgs[org.OrgID]
+		if exists && limiter.Algorithm() == config.AlgorithmOrDefault() {
+			if err := reconfigure(limiter, config); err != nil {
+				log.Printf("Skipping organization %s: %v", org.OrgID, err)
+				continue
+			}
+		} else {
+			if limiter, err = r.newLimiter(orgRef(org.OrgID), config); err != nil {
+				log.Printf("Skipping organization %s: %v", org.OrgID, err)
+				continue
+			}
+			if restore && tokens.Valid && lastRefill.Valid {
+				limiter.Restore(model.LimiterState{Tokens: tokens.Float64, LastRefill: lastRefill.Time})
+			}
+		}
+		orgs[org.OrgID] = limiter
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	r.bucketsMutex.Lock()
+	r.Orgs = orgs
+	r.bucketsMutex.Unlock()
+	log.Printf("Loaded %d organizations from DB", len(orgs))
+	return nil
+}
+
+// reloadOrg re-reads one organization after a change made by another
+// instance. Must be called with r.Mutex held.
+func (r *UserRepoImpl) reloadOrg(orgID string) error {
+	org, found, err := r.GetOrg(orgID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		r.removeOrg(orgID)
+		log.Printf("Organization with ID %s removed by another instance", orgID)
+		return nil
+	}
+	if err := r.applyOrg(org); err != nil {
+		return err
+	}
+	log.Printf("Organization with ID %s updated by another instance", orgID)
+	return nil
+}
+
+// setOrgBucket, removeOrg and setParent must be called with r.Mutex held.
+func (r *UserRepoImpl) setOrgBucket(orgID string, limiter model.Limiter) {
+	orgs := make(map[string]model.Limiter, len(r.Orgs)+1)
+	for id, l := range r.Orgs {
+		orgs[id] = l
+	}
+	orgs[orgID] = limiter
+
+	r.bucketsMutex.Lock()
+	r.Orgs = orgs
+	r.bucketsMutex.Unlock()
+}
+
+// removeOrg drops the organization's bucket and detaches its clients, as
+// ON DELETE SET NULL does in the database.
+func (r *UserRepoImpl) removeOrg(orgID string) {
+	orgs := make(map[string]model.Limiter, len(r.Orgs))
+	for id, l := range r.Orgs {
+		if id != orgID {
+			orgs[id] = l
+		}
+	}
+	parents := make(map[string]string, len(r.parents))
+	for clientID, parent := range r.parents {
+		if parent != orgID {
+			parents[clientID] = parent
+		}
+	}
+
+	r.bucketsMutex.Lock()
+	r.Orgs = orgs
+	r.parents = parents
+	r.bucketsMutex.Unlock()
+}
+
+func (r *UserRepoImpl) setParent(clientID string, orgID string) {
+	if r.parents[clientID] == orgID {
+		return
+	}
+	parents := make(map[string]string, len(r.parents)+1)
+	for id, parent := range r.parents {
+		if id != clientID {
+			parents[id] = parent
+		}
+	}
+	if orgID != "" {
+		parents[clientID] = orgID
+	}
+
+	r.bucketsMutex.Lock()
+	r.parents = parents
+	r.bucketsMutex.Unlock()
+}
+
+// checkOrg verifies that the organization a client is assigned to exists.
+// Must be called with r.Mutex held.
+func (r *UserRepoImpl) checkOrg(orgID string) error {
+	if orgID == "" {
+		return nil
+	}
+	if _, ok := r.Orgs[orgID]; !ok {
+		return fmt.Errorf("organization with ID %s: %w", orgID, ErrNotFound)
+	}
+	return nil
+}
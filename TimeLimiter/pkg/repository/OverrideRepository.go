@@ -0,0 +1,202 @@
+package repository
+
+import (
+	"LoadBalancer/TimeLimiter/pkg/model"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// overrideIdleTimeout is how long an override bucket is kept in memory
+// after its last request. Its balance is saved before it is dropped.
+const overrideIdleTimeout = time.Hour
+
+// overrideKey identifies an override bucket. Tokens of the same client with
+// different limits use separate buckets, so alternating between them does
+// not reset either one.
+type overrideKey struct {
+	clientID   string
+	capacity   int
+	ratePerSec float64
+}
+
+func newOverrideKey(clientID string, config model.ClientConfig) overrideKey {
+	return overrideKey{clientID: clientID, capacity: config.Capacity, ratePerSec: config.RatePerSec}
+}
+
+// id is the key of the bucket in the override_buckets table.
+func (k overrideKey) id() string {
+	return fmt.Sprintf("%s/%d/%g", k.clientID, k.capacity, k.ratePerSec)
+}
+
+// overrideBucket is the bucket used instead of a client's own one while its
+// requests carry a limit override with the settings of its key.
+type overrideBucket struct {
+	mu       sync.Mutex
+	limiter  model.Limiter
+	lastUsed time.Time
+}
+
+// GetOverrideBucket returns the client's override bucket with the given
+// settings, creating it on first use through the limiter factory, so that
+// it is shared between instances like the client buckets are. A bucket that
+// stays local starts from the balance saved by SaveState.
+func (r *UserRepoImpl) GetOverrideBucket(clientID string, config model.ClientConfig) model.Limiter {
+	key := newOverrideKey(clientID, config)
+	value, ok := r.overrides.Load(key)
+	if !ok {
+		value, _ = r.overrides.LoadOrStore(key, &overrideBucket{})
+	}
+
+	ob := value.(*overrideBucket)
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	if ob.limiter == nil {
+		ob.limiter = r.newOverrideLimiter(key, config)
+	}
+	ob.lastUsed = time.Now()
+	return ob.limiter
+}
+
+// OverrideBucket returns the client's most recently used override bucket,
+// or nil if none of its requests carried an override yet.
+func (r *UserRepoImpl) OverrideBucket(clientID string) model.Limiter {
+	var latest model.Limiter
+	var latestUse time.Time
+	r.overrides.Range(func(key, value any) bool {
+		if key.(overrideKey).clientID != clientID {
+			return true
+		}
+		ob := value.(*overrideBucket)
+		ob.mu.Lock()
+		defer ob.mu.Unlock()
+		if ob.limiter != nil && ob.lastUsed.After(latestUse) {
+			latest, latestUse = ob.limiter, ob.lastUsed
+		}
+		return true
+	})
+	return latest
+}
+
+func (r *UserRepoImpl) newOverrideLimiter(key overrideKey, config model.ClientConfig) model.Limiter {
+	r.bucketsMutex.RLock()
+	factory := r.newLimiter
+	r.bucketsMutex.RUnlock()
+
+	limiter, err := factory(BucketRef{Kind: OverrideBucket, ID: key.id()}, config)
+	if err != nil {
+		log.Printf("Failed to create override bucket of client %s, using a local one: %v", key.clientID, err)
+		limiter = model.NewTokenBucket(config.ClientID, config.Capacity, config.RatePerSec)
+	}
+	if _, shared := limiter.(model.SharedLimiter); shared {
+		// The shared balance lives in the row, created full on first use.
+		_, err := r.db.Exec(`
+            INSERT INTO override_buckets (override_id, client_id, capacity, rate_per_sec)
+            VALUES ($1, $2, $3, $4) ON CONFLICT (override_id) DO NOTHING`,
+			key.id(), key.clientID, key.capacity, key.ratePerSec,
+		)
+		if err != nil {
+			log.Printf("Failed to store override bucket of client %s: %v", key.clientID, err)
+		}
+		return limiter
+	}
+
+	var tokens sql.NullFloat64
+	var lastRefill sql.NullTime
+	err = r.db.QueryRow(
+		"SELECT tokens, last_refill FROM override_buckets WHERE override_id = $1",
+		key.id(),
+	).Scan(&tokens, &lastRefill)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to load override bucket of client %s: %v", key.clientID, err)
+	}
+	if tokens.Valid && lastRefill.Valid {
+		limiter.Restore(model.LimiterState{Tokens: tokens.Float64, LastRefill: lastRefill.Time})
+	}
+	return limiter
+}
+
+// overrideStates returns the live balances of the override buckets kept in
+// this instance and drops the buckets that have been idle for
+// overrideIdleTimeout; their balance is among the returned ones.
+func (r *UserRepoImpl) overrideStates() map[overrideKey]model.LimiterState {
+	states := make(map[overrideKey]model.LimiterState)
+	r.overrides.Range(func(key, value any) bool {
+		ob := value.(*overrideBucket)
+		ob.mu.Lock()
+		defer ob.mu.Unlock()
+		if ob.limiter == nil {
+			return true
+		}
+		if _, shared := ob.limiter.(model.SharedLimiter); !shared {
+			states[key.(overrideKey)] = ob.limiter.State()
+		}
+		if time.Since(ob.lastUsed) > overrideIdleTimeout {
+			r.overrides.Delete(key)
+		}
+		return true
+	})
+	return states
+}
+
+// saveOverrideState writes the balances of the local override buckets
+// within the state transaction, with one statement like saveBalances.
+func (r *UserRepoImpl) saveOverrideState(tx *sql.Tx, states map[overrideKey]model.LimiterState) error {
+	if len(states) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(states))
+	clientIDs := make([]string, 0, len(states))
+	capacities := make([]int64, 0, len(states))
+	rates := make([]float64, 0, len(states))
+	tokens := make([]float64, 0, len(states))
+	refills := make([]string, 0, len(states))
+	for key, state := range states {
+		ids = append(ids, key.id())
+		clientIDs = append(clientIDs, key.clientID)
+		capacities = append(capacities, int64(key.capacity))
+		rates = append(rates, key.ratePerSec)
+		tokens = append(tokens, state.Tokens)
+		refills = append(refills, state.LastRefill.Format(time.RFC3339Nano))
+	}
+
+	_, err := tx.Exec(`
+        INSERT INTO override_buckets (override_id, client_id, capacity, rate_per_sec, tokens, last_refill)
+        SELECT s.id, s.client_id, s.capacity, s.rate_per_sec, s.tokens, s.last_refill
+        FROM unnest($1::TEXT[], $2::TEXT[], $3::INTEGER[], $4::DOUBLE PRECISION[], $5::DOUBLE PRECISION[], $6::TIMESTAMPTZ[])
+            AS s (id, client_id, capacity, rate_per_sec, tokens, last_refill)
+        JOIN clients c ON c.client_id = s.client_id
+        ON CONFLICT (override_id) DO UPDATE SET
+            tokens = EXCLUDED.tokens, last_refill = EXCLUDED.last_refill`,
+		pq.Array(ids), pq.Array(clientIDs), pq.Array(capacities), pq.Array(rates), pq.Array(tokens), pq.Array(refills),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save override state: %w", err)
+	}
+	return nil
+}
+
+// pruneOverrides forgets the override buckets of clients that are gone.
+// Must be called with r.Mutex held.
+func (r *UserRepoImpl) pruneOverrides() {
+	r.overrides.Range(func(key, _ any) bool {
+		if _, ok := r.Buckets[key.(overrideKey).clientID]; !ok {
+			r.overrides.Delete(key)
+		}
+		return true
+	})
+}
+
+// deleteOverrides forgets the override buckets of the client.
+func (r *UserRepoImpl) deleteOverrides(clientID string) {
+	r.overrides.Range(func(key, _ any) bool {
+		if key.(overrideKey).clientID == clientID {
+			r.overrides.Delete(key)
+		}
+		return true
+	})
+}
@@ -0,0 +1,376 @@
+package repository
+
+import (
+	"LoadBalancer/TimeLimiter/pkg/model"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+const planColumns = "plan_id, capacity, rate_per_sec, algorithm, window_sec"
+
+// planPolicies selects the route policies defined on plans.
+const planPolicies = `SELECT p.id, '', p.method, p.path, p.capacity, p.rate_per_sec, p.algorithm, p.window_sec, p.plan_id
+	FROM route_policies p`
+
+// ResolveClient returns the client's config with its plan applied, the
+// config the client's limiter is built from.
+func (r *UserRepoImpl) ResolveClient(config model.ClientConfig) (model.ClientConfig, error) {
+	if config.PlanID == "" {
+		return config, nil
+	}
+	r.bucketsMutex.RLock()
+	plan, ok := r.plans[config.PlanID]
+	r.bucketsMutex.RUnlock()
+	if !ok {
+		return config, fmt.Errorf("plan with ID %s: %w", config.PlanID, ErrNotFound)
+	}
+
+	effective := plan.Apply(config)
+	if err := effective.Validate(); err != nil {
+		return config, fmt.Errorf("%w for client %s on plan %s: %v", ErrInvalidConfig, config.ClientID, config.PlanID, err)
+	}
+	return effective, nil
+}
+
+func (r *UserRepoImpl) ListPlans() ([]model.Plan, error) {
+	rows, err := r.db.Query("SELECT " + planColumns + " FROM plans ORDER BY plan_id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query plans: %w", err)
+	}
+	defer rows.Close()
+
+	plans := []model.Plan{}
+	for rows.Next() {
+		var plan model.Plan
+		if err := rows.Scan(&plan.PlanID, &plan.Capacity, &plan.RatePerSec, &plan.Algorithm, &plan.WindowSec); err != nil {
+			return nil, fmt.Errorf("failed to scan plan: %w", err)
+		}
+		plans = append(plans, plan)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return plans, nil
+}
+
+func (r *UserRepoImpl) GetPlan(planID string) (model.Plan, bool, error) {
+	var plan model.Plan
+	err := r.db.QueryRow("SELECT "+planColumns+" FROM plans WHERE plan_id = $1", planID).
+		Scan(&plan.PlanID, &plan.Capacity, &plan.RatePerSec, &plan.Algorithm, &plan.WindowSec)
+	if err == sql.ErrNoRows {
+		return plan, false, nil
+	}
+	if err != nil {
+		return plan, false, fmt.Errorf("failed to load plan %s: %w", planID, err)
+	}
+	return plan, true, nil
+}
+
+// AddPlan creates the plan together with its quotas and route policies in
+// one transaction, so a failed insert leaves nothing behind.
+func (r *UserRepoImpl) AddPlan(plan model.Plan) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	log.Printf("Attempting to add plan with ID %s", plan.PlanID)
+	if _, ok := r.plans[plan.PlanID]; ok {
+		return fmt.Errorf("plan with ID %s already exists", plan.PlanID)
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin plan transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"INSERT INTO plans (plan_id, capacity, rate_per_sec, algorithm, window_sec) VALUES ($1, $2, $3, $4, $5)",
+		plan.PlanID, plan.Capacity, plan.RatePerSec, plan.AlgorithmOrDefault(), plan.WindowSec,
+	)
+	if err != nil {
+		err = fmt.Errorf("failed to insert plan into DB: %w", err)
+		log.Printf("Failed to insert plan with ID %s into DB: %v", plan.PlanID, err)
+		return err
+	}
+	for i, policy := range plan.Policies {
+		if plan.Policies[i], err = insertPlanPolicy(tx, policy); err != nil {
+			log.Printf("Failed to insert route policy for plan with ID %s into DB: %v", plan.PlanID, err)
+			return err
+		}
+	}
+	for i, quota := range plan.Quotas {
+		if plan.Quotas[i], err = insertQuota(tx, quota); err != nil {
+			log.Printf("Failed to insert quota for plan with ID %s into DB: %v", plan.PlanID, err)
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit plan: %w", err)
+	}
+
+	plan.Algorithm = plan.AlgorithmOrDefault()
+	plan.Quotas, plan.Policies = nil, nil
+	// A new plan has no clients yet, so there are no policy buckets to
+	// build; they are created when clients are assigned to it.
+	r.setPlan(plan)
+	r.notifyPlan(ClientUpserted, plan.PlanID)
+	log.Printf("Plan with ID %s added successfully", plan.PlanID)
+	return nil
+}
+
+// UpdatePlan changes the plan and reconfigures the live buckets of all its
+// clients in place, so they keep their current usage.
+func (r *UserRepoImpl) UpdatePlan(plan model.Plan) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	log.Printf("Attempting to update plan with ID %s", plan.PlanID)
+	res, err := r.db.Exec(
+		"UPDATE plans SET capacity = $2, rate_per_sec = $3, algorithm = $4, window_sec = $5 WHERE plan_id = $1",
+		plan.PlanID, plan.Capacity, plan.RatePerSec, plan.AlgorithmOrDefault(), plan.WindowSec,
+	)
+	if err != nil {
+		err = fmt.Errorf("failed to update plan in DB: %w", err)
+		log.Printf("Failed to update plan with ID %s in DB: %v", plan.PlanID, err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("plan with ID %s: %w", plan.PlanID, ErrNotFound)
+	}
+
+	plan.Algorithm = plan.AlgorithmOrDefault()
+	r.setPlan(plan)
+	if err := r.reapplyPlan(plan.PlanID); err != nil {
+		return err
+	}
+	r.notifyPlan(ClientUpserted, plan.PlanID)
+	log.Printf("Plan with ID %s updated successfully", plan.PlanID)
+	return nil
+}
+
+// DeletePlan removes the plan with its quotas and route policies. A plan
+// that clients are still assigned to cannot be deleted.
+func (r *UserRepoImpl) DeletePlan(planID string) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	log.Printf("Attempting to delete plan with ID %s", planID)
+	res, err := r.db.Exec("DELETE FROM plans WHERE plan_id = $1", planID)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation {
+		return fmt.Errorf("plan with ID %s is still assigned to clients: %w", planID, ErrConflict)
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to delete plan from DB: %w", err)
+		log.Printf("Failed to delete plan with ID %s from DB: %v", planID, err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("plan with ID %s: %w", planID, ErrNotFound)
+	}
+
+	r.removePlan(planID)
+	r.notifyPlan(ClientDeleted, planID)
+	log.Printf("Plan with ID %s deleted successfully", planID)
+	return nil
+}
+
+func (r *UserRepoImpl) ListPlanPolicies(planID string) ([]model.RoutePolicy, error) {
+	return r.queryPolicies(planPolicies+" WHERE p.plan_id = $1", planID)
+}
+
+func (r *UserRepoImpl) AddPlanPolicy(policy model.RoutePolicy) (model.RoutePolicy, error) {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	log.Printf("Attempting to add route policy %s %s to plan with ID %s", policy.Method, policy.Path, policy.PlanID)
+	if _, ok := r.plans[policy.PlanID]; !ok {
+		return policy, fmt.Errorf("plan with ID %s: %w", policy.PlanID, ErrNotFound)
+	}
+
+	policy, err := insertPlanPolicy(r.db, policy)
+	if err != nil {
+		log.Printf("Failed to insert route policy for plan with ID %s into DB: %v", policy.PlanID, err)
+		return policy, err
+	}
+
+	if err := r.loadAllPolicies(); err != nil {
+		return policy, err
+	}
+	r.notifyPlan(ClientUpserted, policy.PlanID)
+	log.Printf("Route policy %d added to plan with ID %s", policy.ID, policy.PlanID)
+	return policy, nil
+}
+
+func insertPlanPolicy(db interface {
+	QueryRow(query string, args ...any) *sql.Row
+}, policy model.RoutePolicy) (model.RoutePolicy, error) {
+	err := db.QueryRow(
+		`INSERT INTO route_policies (plan_id, method, path, capacity, rate_per_sec, algorithm, window_sec)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		policy.PlanID, policy.Method, policy.Path, policy.Capacity, policy.RatePerSec, policy.AlgorithmOrDefault(), policy.WindowSec,
+	).Scan(&policy.ID)
+	if err != nil {
+		return policy, fmt.Errorf("failed to insert route policy into DB: %w", err)
+	}
+	policy.Algorithm = policy.AlgorithmOrDefault()
+	return policy, nil
+}
+
+func (r *UserRepoImpl) UpdatePlanPolicy(policy model.RoutePolicy) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	log.Printf("Attempting to update route policy %d of plan with ID %s", policy.ID, policy.PlanID)
+	res, err := r.db.Exec(
+		`UPDATE route_policies SET method = $3, path = $4, capacity = $5, rate_per_sec = $6, algorithm = $7, window_sec = $8
+		WHERE id = $1 AND plan_id = $2`,
+		policy.ID, policy.PlanID, policy.Method, policy.Path, policy.Capacity, policy.RatePerSec, policy.AlgorithmOrDefault(), policy.WindowSec,
+	)
+	if err != nil {
+		err = fmt.Errorf("failed to update route policy in DB: %w", err)
+		log.Printf("Failed to update route policy %d in DB: %v", policy.ID, err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("route policy %d of plan %s: %w", policy.ID, policy.PlanID, ErrNotFound)
+	}
+
+	if err := r.loadAllPolicies(); err != nil {
+		return err
+	}
+	r.notifyPlan(ClientUpserted, policy.PlanID)
+	log.Printf("Route policy %d of plan with ID %s updated successfully", policy.ID, policy.PlanID)
+	return nil
+}
+
+func (r *UserRepoImpl) DeletePlanPolicy(planID string, policyID int64) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	log.Printf("Attempting to delete route policy %d of plan with ID %s", policyID, planID)
+	res, err := r.db.Exec("DELETE FROM route_policies WHERE id = $1 AND plan_id = $2", policyID, planID)
+	if err != nil {
+		err = fmt.Errorf("failed to delete route policy from DB: %w", err)
+		log.Printf("Failed to delete route policy %d from DB: %v", policyID, err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("route policy %d of plan %s: %w", policyID, planID, ErrNotFound)
+	}
+
+	if err := r.loadAllPolicies(); err != nil {
+		return err
+	}
+	r.notifyPlan(ClientUpserted, planID)
+	log.Printf("Route policy %d of plan with ID %s deleted successfully", policyID, planID)
+	return nil
+}
+
+// loadPlans replaces the cached plans. Must be called with r.Mutex held.
+func (r *UserRepoImpl) loadPlans() error {
+	list, err := r.ListPlans()
+	if err != nil {
+		return err
+	}
+	plans := make(map[string]model.Plan, len(list))
+	for _, plan := range list {
+		plans[plan.PlanID] = plan
+	}
+
+	r.bucketsMutex.Lock()
+	r.plans = plans
+	r.bucketsMutex.Unlock()
+	log.Printf("Loaded %d plans from DB", len(plans))
+	return nil
+}
+
+// reloadPlan re-reads one plan after a change made by another instance,
+// which may also have changed the plan's route policies. Must be called
+// with r.Mutex held.
+func (r *UserRepoImpl) reloadPlan(planID string) error {
+	plan, found, err := r.GetPlan(planID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		r.removePlan(planID)
+		log.Printf("Plan with ID %s removed by another instance", planID)
+		return r.loadAllPolicies()
+	}
+	r.setPlan(plan)
+	if err := r.reapplyPlan(planID); err != nil {
+		return err
+	}
+	log.Printf("Plan with ID %s updated by another instance", planID)
+	return r.loadAllPolicies()
+}
+
+// reapplyPlan rebuilds the config of every client on the plan. Clients
+// whose overrides no longer make a valid config with the plan keep their
+// previous limiter. Must be called with r.Mutex held.
+func (r *UserRepoImpl) reapplyPlan(planID string) error {
+	rows, err := r.db.Query("SELECT "+clientColumns+" FROM clients c WHERE c.plan_id = $1", planID)
+	if err != nil {
+		return fmt.Errorf("failed to query clients of plan %s: %w", planID, err)
+	}
+	defer rows.Close()
+
+	var configs []model.ClientConfig
+	for rows.Next() {
+		config, err := scanClient(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan client: %w", err)
+		}
+		configs = append(configs, config)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	for _, config := range configs {
+		effective, err := r.ResolveClient(config)
+		if err == nil {
+			err = r.applyConfig(effective)
+		}
+		if err != nil {
+			log.Printf("Keeping the previous limits of client %s: %v", config.ClientID, err)
+		}
+	}
+	log.Printf("Applied plan %s to %d clients", planID, len(configs))
+	return nil
+}
+
+// setPlan and removePlan must be called with r.Mutex held.
+func (r *UserRepoImpl) setPlan(plan model.Plan) {
+	plans := make(map[string]model.Plan, len(r.plans)+1)
+	for id, p := range r.plans {
+		plans[id] = p
+	}
+	plans[plan.PlanID] = plan
+
+	r.bucketsMutex.Lock()
+	r.plans = plans
+	r.bucketsMutex.Unlock()
+}
+
+func (r *UserRepoImpl) removePlan(planID string) {
+	plans := make(map[string]model.Plan, len(r.plans))
+	for id, p := range r.plans {
+		if id != planID {
+			plans[id] = p
+		}
+	}
+
+	r.bucketsMutex.Lock()
+	r.plans = plans
+	r.bucketsMutex.Unlock()
+}
+
+func (r *UserRepoImpl) notifyPlan(op string, planID string) {
+	r.publish(ClientChange{Op: op, PlanID: planID, Origin: r.instanceID})
+}
@@ -0,0 +1,237 @@
+package repository
+
+import (
+	"LoadBalancer/TimeLimiter/pkg/model"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// foreignKeyViolation is the Postgres error code for a missing referenced row.
+const foreignKeyViolation = "23503"
+
+type QuotaRepo interface {
+	ListQuotas() ([]model.Quota, error)
+	ListClientQuotas(clientID string) ([]model.Quota, error)
+	ListPlanQuotas(planID string) ([]model.Quota, error)
+	AddQuota(quota model.Quota) (model.Quota, error)
+	DeleteQuota(clientID string, quotaID int64) error
+	DeletePlanQuota(planID string, quotaID int64) error
+	AddUsage(increments []QuotaIncrement) error
+	UsageTotals(windows map[QuotaKey]time.Time) (map[QuotaKey]float64, error)
+	PruneUsage(before time.Time) error
+}
+
+// QuotaKey identifies the usage of a quota by one client; a quota of a plan
+// is counted separately for every client on the plan.
+type QuotaKey struct {
+	QuotaID  int64
+	ClientID string
+}
+
+// QuotaIncrement is the usage of one quota bucket accumulated since the
+// last flush.
+type QuotaIncrement struct {
+	QuotaKey
+	Bucket time.Time
+	Used   float64
+}
+
+type QuotaRepoImpl struct {
+	db *sql.DB
+}
+
+func NewQuotaRepoImpl(db *sql.DB) *QuotaRepoImpl {
+	return &QuotaRepoImpl{
+		db: db,
+	}
+}
+
+// clientQuotas selects the quotas of clients, a quota of a plan once for
+// every client on the plan. The two kinds are joined separately, an OR in
+// the join condition would rule out anything but a nested loop.
+const clientQuotas = `SELECT id, client_id, period, quota, timezone, rolling, plan_id FROM (
+		SELECT q.id, q.client_id, q.period, q.quota, q.timezone, q.rolling, '' AS plan_id
+		FROM quotas q WHERE q.client_id IS NOT NULL
+		UNION ALL
+		SELECT q.id, c.client_id, q.period, q.quota, q.timezone, q.rolling, q.plan_id
+		FROM quotas q JOIN clients c ON c.plan_id = q.plan_id
+	) q`
+
+// ListQuotas returns the quotas of every client.
+func (r *QuotaRepoImpl) ListQuotas() ([]model.Quota, error) {
+	return r.queryQuotas(clientQuotas + " ORDER BY id")
+}
+
+// ListClientQuotas returns the client's own quotas and those of its plan.
+func (r *QuotaRepoImpl) ListClientQuotas(clientID string) ([]model.Quota, error) {
+	return r.queryQuotas(clientQuotas+" WHERE client_id = $1 ORDER BY id", clientID)
+}
+
+func (r *QuotaRepoImpl) ListPlanQuotas(planID string) ([]model.Quota, error) {
+	return r.queryQuotas(`SELECT id, '', period, quota, timezone, rolling, plan_id
+		FROM quotas WHERE plan_id = $1 ORDER BY id`, planID)
+}
+
+func (r *QuotaRepoImpl) queryQuotas(query string, args ...any) ([]model.Quota, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query quotas: %w", err)
+	}
+	defer rows.Close()
+
+	quotas := []model.Quota{}
+	for rows.Next() {
+		var q model.Quota
+		if err := rows.Scan(&q.ID, &q.ClientID, &q.Period, &q.Limit, &q.Timezone, &q.Rolling, &q.PlanID); err != nil {
+			return nil, fmt.Errorf("failed to scan quota: %w", err)
+		}
+		quotas = append(quotas, q)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return quotas, nil
+}
+
+// AddQuota adds a quota to the client or, when PlanID is set, to the plan.
+func (r *QuotaRepoImpl) AddQuota(quota model.Quota) (model.Quota, error) {
+	return insertQuota(r.db, quota)
+}
+
+func insertQuota(db interface {
+	QueryRow(query string, args ...any) *sql.Row
+}, quota model.Quota) (model.Quota, error) {
+	owner := "client with ID " + quota.ClientID
+	if quota.PlanID != "" {
+		owner = "plan with ID " + quota.PlanID
+	}
+
+	err := db.QueryRow(
+		`INSERT INTO quotas (client_id, plan_id, period, quota, timezone, rolling)
+		VALUES (NULLIF($1, ''), NULLIF($2, ''), $3, $4, $5, $6) RETURNING id`,
+		quota.ClientID, quota.PlanID, quota.Period, quota.Limit, quota.Timezone, quota.Rolling,
+	).Scan(&quota.ID)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation {
+		return quota, fmt.Errorf("%s: %w", owner, ErrNotFound)
+	}
+	if err != nil {
+		return quota, fmt.Errorf("failed to insert quota into DB: %w", err)
+	}
+	log.Printf("Quota %d added for %s", quota.ID, owner)
+	return quota, nil
+}
+
+func (r *QuotaRepoImpl) DeleteQuota(clientID string, quotaID int64) error {
+	res, err := r.db.Exec("DELETE FROM quotas WHERE id = $1 AND client_id = $2", quotaID, clientID)
+	if err != nil {
+		return fmt.Errorf("failed to delete quota from DB: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("quota %d of client %s: %w", quotaID, clientID, ErrNotFound)
+	}
+	log.Printf("Quota %d of client with ID %s deleted", quotaID, clientID)
+	return nil
+}
+
+func (r *QuotaRepoImpl) DeletePlanQuota(planID string, quotaID int64) error {
+	res, err := r.db.Exec("DELETE FROM quotas WHERE id = $1 AND plan_id = $2", quotaID, planID)
+	if err != nil {
+		return fmt.Errorf("failed to delete quota from DB: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("quota %d of plan %s: %w", quotaID, planID, ErrNotFound)
+	}
+	log.Printf("Quota %d of plan with ID %s deleted", quotaID, planID)
+	return nil
+}
+
+// AddUsage adds the increments to the stored usage in one transaction.
+// Increments of quotas deleted meanwhile are dropped.
+func (r *QuotaRepoImpl) AddUsage(increments []QuotaIncrement) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin usage transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+        INSERT INTO quota_usage (quota_id, client_id, bucket_start, used)
+        SELECT id, $2, $3, $4 FROM quotas WHERE id = $1
+        ON CONFLICT (quota_id, client_id, bucket_start) DO UPDATE SET used = quota_usage.used + EXCLUDED.used`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare usage update: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, inc := range increments {
+		if _, err := stmt.Exec(inc.QuotaID, inc.ClientID, inc.Bucket, inc.Used); err != nil {
+			return fmt.Errorf("failed to add usage of quota %d: %w", inc.QuotaID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit usage: %w", err)
+	}
+	return nil
+}
+
+// UsageTotals sums the stored usage of every quota and client from the
+// start of its window.
+func (r *QuotaRepoImpl) UsageTotals(windows map[QuotaKey]time.Time) (map[QuotaKey]float64, error) {
+	totals := make(map[QuotaKey]float64, len(windows))
+	if len(windows) == 0 {
+		return totals, nil
+	}
+
+	ids := make([]int64, 0, len(windows))
+	clients := make([]string, 0, len(windows))
+	since := make([]string, 0, len(windows))
+	for key, start := range windows {
+		ids = append(ids, key.QuotaID)
+		clients = append(clients, key.ClientID)
+		since = append(since, start.Format(time.RFC3339Nano))
+	}
+
+	rows, err := r.db.Query(`
+        SELECT u.quota_id, u.client_id, SUM(u.used)
+        FROM quota_usage u
+        JOIN unnest($1::BIGINT[], $2::TEXT[], $3::TIMESTAMPTZ[]) AS w (quota_id, client_id, since)
+            ON u.quota_id = w.quota_id AND u.client_id = w.client_id AND u.bucket_start >= w.since
+        GROUP BY u.quota_id, u.client_id`,
+		pq.Array(ids), pq.Array(clients), pq.Array(since),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query quota usage: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key QuotaKey
+		var used float64
+		if err := rows.Scan(&key.QuotaID, &key.ClientID, &used); err != nil {
+			return nil, fmt.Errorf("failed to scan quota usage: %w", err)
+		}
+		totals[key] = used
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return totals, nil
+}
+
+func (r *QuotaRepoImpl) PruneUsage(before time.Time) error {
+	res, err := r.db.Exec("DELETE FROM quota_usage WHERE bucket_start < $1", before)
+	if err != nil {
+		return fmt.Errorf("failed to prune quota usage: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		log.Printf("Pruned %d expired quota usage buckets", n)
+	}
+	return nil
+}
@@ -7,7 +7,12 @@ import (
 
 type RateLimiterRepo interface {
 	GetClients() error
-	GetBuckets() map[string]*model.TokenBucket
+	GetBuckets() map[string]model.Limiter
+	GetPolicies() map[string][]*model.PolicyLimiter
+	GetOrgBucket(clientID string) model.Limiter
+	GetOverrideBucket(clientID string, config model.ClientConfig) model.Limiter
+	OverrideBucket(clientID string) model.Limiter
+	SaveState() error
 }
 
 type RateLimiterRepoImpl struct {
@@ -25,6 +30,26 @@ func (r *RateLimiterRepoImpl) GetClients() error {
 	return r.usRepo.GetClients()
 }
 
-func (r *RateLimiterRepoImpl) GetBuckets() map[string]*model.TokenBucket {
+func (r *RateLimiterRepoImpl) GetBuckets() map[string]model.Limiter {
 	return r.usRepo.GetBuckets()
 }
+
+func (r *RateLimiterRepoImpl) GetPolicies() map[string][]*model.PolicyLimiter {
+	return r.usRepo.GetPolicies()
+}
+
+func (r *RateLimiterRepoImpl) GetOrgBucket(clientID string) model.Limiter {
+	return r.usRepo.GetOrgBucket(clientID)
+}
+
+func (r *RateLimiterRepoImpl) GetOverrideBucket(clientID string, config model.ClientConfig) model.Limiter {
+	return r.usRepo.GetOverrideBucket(clientID, config)
+}
+
+func (r *RateLimiterRepoImpl) OverrideBucket(clientID string) model.Limiter {
+	return r.usRepo.OverrideBucket(clientID)
+}
+
+func (r *RateLimiterRepoImpl) SaveState() error {
+	return r.usRepo.SaveState()
+}
@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"LoadBalancer/TimeLimiter/pkg/model"
+	"errors"
+	"fmt"
+	"log"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidConfig is returned when a client's settings are invalid
+	// once its plan is applied.
+	ErrInvalidConfig = errors.New("invalid config")
+	// ErrConflict is returned when a row is still referenced, e.g. a plan
+	// that clients are assigned to.
+	ErrConflict = errors.New("conflict")
+)
+
+// clientPolicies selects the route policies of clients, a policy of a plan
+// once for every client on the plan. Like clientQuotas it is a union of two
+// equality joins rather than one join on an OR.
+const clientPolicies = `SELECT p.id, p.client_id, p.method, p.path, p.capacity, p.rate_per_sec, p.algorithm, p.window_sec, p.plan_id FROM (
+		SELECT id, client_id, method, path, capacity, rate_per_sec, algorithm, window_sec, '' AS plan_id
+		FROM route_policies WHERE client_id IS NOT NULL
+		UNION ALL
+		SELECT r.id, c.client_id, r.method, r.path, r.capacity, r.rate_per_sec, r.algorithm, r.window_sec, r.plan_id
+		FROM route_policies r JOIN clients c ON c.plan_id = r.plan_id
+	) p`
+
+func (r *UserRepoImpl) GetPolicies() map[string][]*model.PolicyLimiter {
+	r.bucketsMutex.RLock()
+	defer r.bucketsMutex.RUnlock()
+	return r.Policies
+}
+
+// ListPolicies returns the client's own policies and those of its plan.
+func (r *UserRepoImpl) ListPolicies(clientID string) ([]model.RoutePolicy, error) {
+	return r.queryPolicies(clientPolicies+" WHERE p.client_id = $1", clientID)
+}
+
+func (r *UserRepoImpl) AddPolicy(policy model.RoutePolicy) (model.RoutePolicy, error) {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	log.Printf("Attempting to add route policy %s %s for client with ID %s", policy.Method, policy.Path, policy.ClientID)
+	if _, ok := r.Buckets[policy.ClientID]; !ok {
+		return policy, fmt.Errorf("client with ID %s: %w", policy.ClientID, ErrNotFound)
+	}
+
+	err := r.db.QueryRow(
+		`INSERT INTO route_policies (client_id, method, path, capacity, rate_per_sec, algorithm, window_sec)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		policy.ClientID, policy.Method, policy.Path, policy.Capacity, policy.RatePerSec, policy.AlgorithmOrDefault(), policy.WindowSec,
+	).Scan(&policy.ID)
+	if err != nil {
+		err = fmt.Errorf("failed to insert route policy into DB: %w", err)
+		log.Printf("Failed to insert route policy for client with ID %s into DB: %v", policy.ClientID, err)
+		return policy, err
+	}
+	policy.Algorithm = policy.AlgorithmOrDefault()
+
+	if err := r.reloadPolicies(policy.ClientID); err != nil {
+		return policy, err
+	}
+	r.notify(ClientUpserted, policy.ClientID)
+	log.Printf("Route policy %d added for client with ID %s", policy.ID, policy.ClientID)
+	return policy, nil
+}
+
+func (r *UserRepoImpl) UpdatePolicy(policy model.RoutePolicy) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	log.Printf("Attempting to update route policy %d of client with ID %s", policy.ID, policy.ClientID)
+	res, err := r.db.Exec(
+		`UPDATE route_policies SET method = $3, path = $4, capacity = $5, rate_per_sec = $6, algorithm = $7, window_sec = $8
+		WHERE id = $1 AND client_id = $2`,
+		policy.ID, policy.ClientID, policy.Method, policy.Path, policy.Capacity, policy.RatePerSec, policy.AlgorithmOrDefault(), policy.WindowSec,
+	)
+	if err != nil {
+		err = fmt.Errorf("failed to update route policy in DB: %w", err)
+		log.Printf("Failed to update route policy %d in DB: %v", policy.ID, err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("route policy %d of client %s: %w", policy.ID, policy.ClientID, ErrNotFound)
+	}
+
+	if err := r.reloadPolicies(policy.ClientID); err != nil {
+		return err
+	}
+	r.notify(ClientUpserted, policy.ClientID)
+	log.Printf("Route policy %d of client with ID %s updated successfully", policy.ID, policy.ClientID)
+	return nil
+}
+
+func (r *UserRepoImpl) DeletePolicy(clientID string, policyID int64) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	log.Printf("Attempting to delete route policy %d of client with ID %s", policyID, clientID)
+	res, err := r.db.Exec("DELETE FROM route_policies WHERE id = $1 AND client_id = $2", policyID, clientID)
+	if err != nil {
+		err = fmt.Errorf("failed to delete route policy from DB: %w", err)
+		log.Printf("Failed to delete route policy %d from DB: %v", policyID, err)
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("route policy %d of client %s: %w", policyID, clientID, ErrNotFound)
+	}
+
+	if err := r.reloadPolicies(clientID); err != nil {
+		return err
+	}
+	r.notify(ClientUpserted, clientID)
+	log.Printf("Route policy %d of client with ID %s deleted successfully", policyID, clientID)
+	return nil
+}
+
+func (r *UserRepoImpl) queryPolicies(query string, args ...any) ([]model.RoutePolicy, error) {
+	rows, err := r.db.Query(query+" ORDER BY p.id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query route policies: %w", err)
+	}
+	defer rows.Close()
+
+	policies := []model.RoutePolicy{}
+	for rows.Next() {
+		var p model.RoutePolicy
+		if err := rows.Scan(&p.ID, &p.ClientID, &p.Method, &p.Path, &p.Capacity, &p.RatePerSec, &p.Algorithm, &p.WindowSec, &p.PlanID); err != nil {
+			return nil, fmt.Errorf("failed to scan route policy: %w", err)
+		}
+		policies = append(policies, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return policies, nil
+}
+
+// policyKey identifies a policy's bucket; policies of a plan have one per
+// client.
+type policyKey struct {
+	id       int64
+	clientID string
+}
+
+// buildPolicyLimiters groups the policies by client. Limiters of policies
+// that are already loaded are kept and reconfigured, so editing or syncing
+// a policy does not reset its usage. Policy buckets are always local to the
+// instance, the shared token state only exists for the clients table.
+// Must be called with r.Mutex held.
+func (r *UserRepoImpl) buildPolicyLimiters(policies []model.RoutePolicy) map[string][]*model.PolicyLimiter {
+	live := make(map[policyKey]model.Limiter)
+	for clientID, list := range r.Policies {
+		for _, pl := range list {
+			live[policyKey{pl.Policy.ID, clientID}] = pl.Limiter
+		}
+	}
+
+	result := make(map[string][]*model.PolicyLimiter)
+	for _, policy := range policies {
+		config := policy.LimiterConfig()
+		limiter, exists := live[policyKey{policy.ID, policy.ClientID}]
+		var err error
+		if exists && limiter.Algorithm() == config.AlgorithmOrDefault() {
+			err = reconfigure(limiter, config)
+		} else {
+			limiter, err = model.NewLimiter(config)
+		}
+		if err != nil {
+			log.Printf("Skipping route policy %d of client %s: %v", policy.ID, policy.ClientID, err)
+			continue
+		}
+		result[policy.ClientID] = append(result[policy.ClientID], &model.PolicyLimiter{Policy: policy, Limiter: limiter})
+	}
+	return result
+}
+
+// reloadPolicies re-reads the policies of one client. Must be called with
+// r.Mutex held.
+func (r *UserRepoImpl) reloadPolicies(clientID string) error {
+	policies, err := r.ListPolicies(clientID)
+	if err != nil {
+		return err
+	}
+	r.setPolicies(clientID, r.buildPolicyLimiters(policies)[clientID])
+	return nil
+}
+
+// setPolicies replaces the policies of one client copy-on-write, an empty
+// list removes the client's entry. Must be called with r.Mutex held.
+func (r *UserRepoImpl) setPolicies(clientID string, list []*model.PolicyLimiter) {
+	policies := make(map[string][]*model.PolicyLimiter, len(r.Policies)+1)
+	for id, l := range r.Policies {
+		if id != clientID {
+			policies[id] = l
+		}
+	}
+	if len(list) > 0 {
+		policies[clientID] = list
+	}
+
+	r.bucketsMutex.Lock()
+	r.Policies = policies
+	r.bucketsMutex.Unlock()
+}
+
+// loadAllPolicies rebuilds the policies of every client. Must be called with
+// r.Mutex held.
+func (r *UserRepoImpl) loadAllPolicies() error {
+	policies, err := r.queryPolicies(clientPolicies)
+	if err != nil {
+		return err
+	}
+	built := r.buildPolicyLimiters(policies)
+
+	r.bucketsMutex.Lock()
+	r.Policies = built
+	r.bucketsMutex.Unlock()
+	log.Printf("Loaded %d route policies from DB", len(policies))
+	return nil
+}
@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Kinds of buckets whose balance can be shared through the database.
+const (
+	ClientBucket   = "client"
+	OrgBucket      = "org"
+	OverrideBucket = "override"
+)
+
+// BucketRef names a bucket stored in the database: a client's own bucket,
+// an organization's pooled bucket or the bucket a client's limit override
+// uses in place of its own.
+type BucketRef struct {
+	Kind string
+	ID   string
+}
+
+func (ref BucketRef) String() string {
+	return ref.Kind + " " + ref.ID
+}
+
+// TokenStateRepo operates on the token balances stored in the clients,
+// organizations and override_buckets tables so that several balancer
+// instances can share one bucket. Every statement refills the bucket for the
+// time elapsed since last_refill first, the row lock taken by SELECT ... FOR
+// UPDATE makes the read-modify-write atomic.
+type TokenStateRepo interface {
+	TakeTokens(ref BucketRef, min float64, max float64) (TokenGrant, error)
+	ReturnTokens(ref BucketRef, n float64) error
+}
+
+type TokenGrant struct {
+	Granted    float64
+	Tokens     float64
+	Capacity   int
+	RatePerSec float64
+}
+
+type TokenStateRepoImpl struct {
+	db *sql.DB
+}
+
+func NewTokenStateRepoImpl(db *sql.DB) *TokenStateRepoImpl {
+	return &TokenStateRepoImpl{
+		db: db,
+	}
+}
+
+// bucketTable describes where a kind of bucket lives. from selects the row
+// aliased c; capacity and rate are the expressions of its settings.
+type bucketTable struct {
+	table    string
+	key      string
+	from     string
+	capacity string
+	rate     string
+}
+
+var bucketTables = map[string]bucketTable{
+	// Clients on a plan inherit the settings they leave NULL.
+	ClientBucket: {
+		table:    "clients",
+		key:      "client_id",
+		from:     "clients c LEFT JOIN plans p ON p.plan_id = c.plan_id",
+		capacity: "COALESCE(c.capacity, p.capacity)",
+		rate:     "COALESCE(c.rate_per_sec, p.rate_per_sec)",
+	},
+	OrgBucket: {
+		table:    "organizations",
+		key:      "org_id",
+		from:     "organizations c",
+		capacity: "c.capacity",
+		rate:     "c.rate_per_sec",
+	},
+	OverrideBucket: {
+		table:    "override_buckets",
+		key:      "override_id",
+		from:     "override_buckets c",
+		capacity: "c.capacity",
+		rate:     "c.rate_per_sec",
+	},
+}
+
+func lookupBucketTable(ref BucketRef) (bucketTable, error) {
+	t, ok := bucketTables[ref.Kind]
+	if !ok {
+		return t, fmt.Errorf("unknown bucket kind %q", ref.Kind)
+	}
+	return t, nil
+}
+
+// current selects the locked row with its refilled balance and settings.
+func (t bucketTable) current() string {
+	return `SELECT c.` + t.key + ` AS id, ` + t.capacity + ` AS capacity, ` + t.rate + ` AS rate_per_sec,
+                LEAST(` + t.capacity + `, COALESCE(c.tokens, ` + t.capacity + `) +
+                    ` + t.rate + ` * GREATEST(0, EXTRACT(EPOCH FROM now() - COALESCE(c.last_refill, now())))) AS available
+            FROM ` + t.from + `
+            WHERE c.` + t.key + ` = $1 FOR UPDATE OF c`
+}
+
+// TakeTokens grants up to max tokens if at least min are available, and
+// nothing otherwise.
+func (r *TokenStateRepoImpl) TakeTokens(ref BucketRef, min float64, max float64) (TokenGrant, error) {
+	var grant TokenGrant
+	t, err := lookupBucketTable(ref)
+	if err != nil {
+		return grant, err
+	}
+	err = r.db.QueryRow(`
+        WITH cur AS (
+            `+t.current()+`
+        ), grant_ AS (
+            SELECT id, available, capacity, rate_per_sec,
+                CASE WHEN available >= $2 THEN LEAST(available, $3) ELSE 0 END AS granted
+            FROM cur
+        )
+        UPDATE `+t.table+` c SET tokens = g.available - g.granted, last_refill = now()
+        FROM grant_ g WHERE c.`+t.key+` = g.id
+        RETURNING g.granted, c.tokens, g.capacity, g.rate_per_sec`,
+		ref.ID, min, max,
+	).Scan(&grant.Granted, &grant.Tokens, &grant.Capacity, &grant.RatePerSec)
+	if err == sql.ErrNoRows {
+		return grant, fmt.Errorf("%s not found", ref)
+	}
+	if err != nil {
+		return grant, fmt.Errorf("failed to take tokens: %w", err)
+	}
+	return grant, nil
+}
+
+// ReturnTokens credits (positive n) or debits (negative n) the shared
+// bucket, keeping it within [-capacity, capacity].
+func (r *TokenStateRepoImpl) ReturnTokens(ref BucketRef, n float64) error {
+	t, err := lookupBucketTable(ref)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec(`
+        WITH cur AS (
+            `+t.current()+`
+        )
+        UPDATE `+t.table+` c SET
+            tokens = GREATEST(-cur.capacity, LEAST(cur.capacity, cur.available + $2)),
+            last_refill = now()
+        FROM cur WHERE c.`+t.key+` = cur.id`,
+		ref.ID, n,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to return tokens: %w", err)
+	}
+	return nil
+}
@@ -2,37 +2,146 @@ package repository
 
 import (
 	"LoadBalancer/TimeLimiter/pkg/model"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const ClientChangesChannel = "client_changes"
+
+// clientColumns are the client's own settings; the ones a client on a plan
+// inherits are NULL and read as zero.
+const clientColumns = "c.client_id, COALESCE(c.capacity, 0), COALESCE(c.rate_per_sec, 0), COALESCE(c.algorithm, ''), COALESCE(c.window_sec, 0), COALESCE(c.org_id, ''), COALESCE(c.plan_id, '')"
+
+const (
+	ClientUpserted = "upsert"
+	ClientDeleted  = "delete"
 )
 
 type UserRepo interface {
 	GetClients() error
-	GetBuckets() map[string]*model.TokenBucket
+	GetBuckets() map[string]model.Limiter
 	AddClient(config model.ClientConfig) error
 	DeleteClient(clientID string) error
 	UpdateClient(config model.ClientConfig) error
+	SaveState() error
+	SyncClients() error
+	ApplyChange(change ClientChange) error
+	InstanceID() string
+	ListClients(filter model.ClientFilter) ([]model.ClientConfig, int, error)
+	GetClient(clientID string) (model.ClientConfig, bool, error)
+	GetPolicies() map[string][]*model.PolicyLimiter
+	ListPolicies(clientID string) ([]model.RoutePolicy, error)
+	AddPolicy(policy model.RoutePolicy) (model.RoutePolicy, error)
+	UpdatePolicy(policy model.RoutePolicy) error
+	DeletePolicy(clientID string, policyID int64) error
+	GetOrgBucket(clientID string) model.Limiter
+	GetOrgBuckets() map[string]model.Limiter
+	ListOrgs() ([]model.Organization, error)
+	GetOrg(orgID string) (model.Organization, bool, error)
+	AddOrg(org model.Organization) error
+	UpdateOrg(org model.Organization) error
+	DeleteOrg(orgID string) error
+	ResolveClient(config model.ClientConfig) (model.ClientConfig, error)
+	ListPlans() ([]model.Plan, error)
+	GetPlan(planID string) (model.Plan, bool, error)
+	AddPlan(plan model.Plan) error
+	UpdatePlan(plan model.Plan) error
+	DeletePlan(planID string) error
+	ListPlanPolicies(planID string) ([]model.RoutePolicy, error)
+	AddPlanPolicy(policy model.RoutePolicy) (model.RoutePolicy, error)
+	UpdatePlanPolicy(policy model.RoutePolicy) error
+	DeletePlanPolicy(planID string, policyID int64) error
 }
 
+// LimiterFactory creates the limiter of a bucket; ref tells where its state
+// is stored if the limiter shares it through the database.
+type LimiterFactory func(ref BucketRef, config model.ClientConfig) (model.Limiter, error)
+
+func newLocalLimiter(ref BucketRef, config model.ClientConfig) (model.Limiter, error) {
+	return model.NewLimiter(config)
+}
+
+// ClientChange is the payload of the notifications sent on
+// ClientChangesChannel whenever an instance modifies a client or, when
+// OrgID or PlanID is set, an organization or a plan.
+type ClientChange struct {
+	Op       string `json:"op"`
+	ClientID string `json:"client_id,omitempty"`
+	OrgID    string `json:"org_id,omitempty"`
+	PlanID   string `json:"plan_id,omitempty"`
+	Origin   string `json:"origin"`
+}
+
+// UserRepoImpl keeps Buckets, Policies, Orgs, plans and the client to
+// organization links copy-on-write: writers, serialized by Mutex, replace
+// the maps instead of modifying them, so the request path can read them
+// without waiting for database round trips. Override buckets are created
+// on the request path and kept in a sync.Map instead.
 type UserRepoImpl struct {
-	db      *sql.DB
-	Mutex   sync.Mutex
-	Buckets map[string]*model.TokenBucket
+	db           *sql.DB
+	Mutex        sync.Mutex
+	bucketsMutex sync.RWMutex
+	Buckets      map[string]model.Limiter
+	Policies     map[string][]*model.PolicyLimiter
+	Orgs         map[string]model.Limiter
+	parents      map[string]string
+	plans        map[string]model.Plan
+	overrides    sync.Map
+	newLimiter   LimiterFactory
+	instanceID   string
 }
 
 func NewUserRepoImpl(db *sql.DB) *UserRepoImpl {
 	log.Println("Creating new UserRepoImpl")
 	return &UserRepoImpl{
-		db:      db,
-		Mutex:   sync.Mutex{},
-		Buckets: make(map[string]*model.TokenBucket),
+		db:         db,
+		Mutex:      sync.Mutex{},
+		Buckets:    make(map[string]model.Limiter),
+		Policies:   make(map[string][]*model.PolicyLimiter),
+		Orgs:       make(map[string]model.Limiter),
+		parents:    make(map[string]string),
+		plans:      make(map[string]model.Plan),
+		newLimiter: newLocalLimiter,
+		instanceID: newInstanceID(),
+	}
+}
+
+func newInstanceID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
 	}
+	return hex.EncodeToString(b)
+}
+
+func (r *UserRepoImpl) InstanceID() string {
+	return r.instanceID
+}
+
+func (r *UserRepoImpl) SetLimiterFactory(factory LimiterFactory) {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	r.bucketsMutex.Lock()
+	defer r.bucketsMutex.Unlock()
+	r.newLimiter = factory
+}
+
+func clientRef(clientID string) BucketRef {
+	return BucketRef{Kind: ClientBucket, ID: clientID}
 }
 
 func (r *UserRepoImpl) AddClient(config model.ClientConfig) error {
 	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
 
 	log.Printf("Attempting to add client with ID %s", config.ClientID)
 	if _, ok := r.Buckets[config.ClientID]; ok {
@@ -40,10 +149,32 @@ func (r *UserRepoImpl) AddClient(config model.ClientConfig) error {
 		log.Printf("Client with ID %s already exists: %v", config.ClientID, err)
 		return err
 	}
+	if err := r.checkOrg(config.OrgID); err != nil {
+		return err
+	}
+	effective, err := r.ResolveClient(config)
+	if err != nil {
+		return err
+	}
+
+	limiter, err := r.newLimiter(clientRef(config.ClientID), effective)
+	if err != nil {
+		log.Printf("Failed to create limiter for client with ID %s: %v", config.ClientID, err)
+		return err
+	}
+	if config.CurrentTokens > 0 {
+		limiter.Restore(model.LimiterState{Tokens: config.CurrentTokens, LastRefill: time.Now()})
+	}
 
-	_, err := r.db.Exec(
-		"INSERT INTO clients (client_id, capacity, rate_per_sec) VALUES ($1, $2, $3)",
-		config.ClientID, config.Capacity, config.RatePerSec,
+	var initialTokens sql.NullFloat64
+	if config.CurrentTokens > 0 {
+		initialTokens = sql.NullFloat64{Float64: config.CurrentTokens, Valid: true}
+	}
+	capacity, rate, algorithm, window := clientSettings(config)
+	_, err = r.db.Exec(
+		`INSERT INTO clients (client_id, capacity, rate_per_sec, algorithm, window_sec, tokens, last_refill, org_id, plan_id)
+		VALUES ($1, $2, $3, $4, $5, $6::DOUBLE PRECISION, CASE WHEN $6 IS NULL THEN NULL ELSE now() END, NULLIF($7, ''), NULLIF($8, ''))`,
+		config.ClientID, capacity, rate, algorithm, window, initialTokens, config.OrgID, config.PlanID,
 	)
 	if err != nil {
 		err = fmt.Errorf("failed to insert client into DB: %w", err)
@@ -53,18 +184,21 @@ func (r *UserRepoImpl) AddClient(config model.ClientConfig) error {
 
 	log.Printf("Successfully inserted client with ID %s into DB", config.ClientID)
 
-	r.Buckets[config.ClientID] = model.NewTokenBucket(
-		config.ClientID,
-		config.Capacity,
-		config.RatePerSec,
-	)
-	r.Mutex.Unlock()
+	r.setBucket(config.ClientID, limiter)
+	r.setParent(config.ClientID, config.OrgID)
+	if config.PlanID != "" {
+		if err := r.reloadPolicies(config.ClientID); err != nil {
+			log.Printf("Failed to load route policies of client with ID %s: %v", config.ClientID, err)
+		}
+	}
+	r.notify(ClientUpserted, config.ClientID)
 	log.Printf("Client with ID %s added successfully", config.ClientID)
 	return nil
 }
 
 func (r *UserRepoImpl) DeleteClient(clientID string) error {
 	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
 
 	log.Printf("Attempting to delete client with ID %s", clientID)
 
@@ -76,20 +210,32 @@ func (r *UserRepoImpl) DeleteClient(clientID string) error {
 	}
 
 	log.Printf("Successfully deleted client with ID %s from DB", clientID)
-	delete(r.Buckets, clientID)
-	r.Mutex.Unlock()
+	r.deleteBucket(clientID)
+	r.setPolicies(clientID, nil)
+	r.setParent(clientID, "")
+	r.notify(ClientDeleted, clientID)
 	log.Printf("Client with ID %s deleted successfully", clientID)
 	return nil
 }
 
 func (r *UserRepoImpl) UpdateClient(config model.ClientConfig) error {
 	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
 
 	log.Printf("Attempting to update client with ID %s", config.ClientID)
+	if err := r.checkOrg(config.OrgID); err != nil {
+		return err
+	}
+	effective, err := r.ResolveClient(config)
+	if err != nil {
+		return err
+	}
 
-	_, err := r.db.Exec(
-		"UPDATE clients SET capacity = $2, rate_per_sec = $3 WHERE client_id = $1",
-		config.ClientID, config.Capacity, config.RatePerSec,
+	capacity, rate, algorithm, window := clientSettings(config)
+	_, err = r.db.Exec(
+		`UPDATE clients SET capacity = $2, rate_per_sec = $3, algorithm = $4, window_sec = $5, org_id = NULLIF($6, ''), plan_id = NULLIF($7, '')
+		WHERE client_id = $1`,
+		config.ClientID, capacity, rate, algorithm, window, config.OrgID, config.PlanID,
 	)
 	if err != nil {
 		err = fmt.Errorf("failed to update client in DB: %w", err)
@@ -99,28 +245,96 @@ func (r *UserRepoImpl) UpdateClient(config model.ClientConfig) error {
 
 	log.Printf("Successfully updated client with ID %s in DB", config.ClientID)
 
-	if bucket, exists := r.Buckets[config.ClientID]; exists {
-		bucket.SetCapacity(config.Capacity)
-		bucket.SetRate(config.RatePerSec)
-	} else {
-		r.Buckets[config.ClientID] = model.NewTokenBucket(
-			config.ClientID,
-			config.Capacity,
-			config.RatePerSec,
-		)
+	if err := r.applyConfig(effective); err != nil {
+		log.Printf("Failed to apply config for client with ID %s: %v", config.ClientID, err)
+		return err
 	}
-	r.Mutex.Unlock()
+	r.setParent(config.ClientID, config.OrgID)
+	// The plan, and with it the plan's route policies, may have changed.
+	if err := r.reloadPolicies(config.ClientID); err != nil {
+		log.Printf("Failed to reload route policies of client with ID %s: %v", config.ClientID, err)
+	}
+	r.notify(ClientUpserted, config.ClientID)
 	log.Printf("Client with ID %s updated successfully", config.ClientID)
 	return nil
 }
 
+// applyConfig updates the live limiter in place when the algorithm is
+// unchanged, so that the client keeps its current usage, and replaces it
+// otherwise. The config must already have the client's plan applied. Must
+// be called with r.Mutex held.
+func (r *UserRepoImpl) applyConfig(config model.ClientConfig) error {
+	limiter, exists := r.Buckets[config.ClientID]
+	if !exists || limiter.Algorithm() != config.AlgorithmOrDefault() {
+		limiter, err := r.newLimiter(clientRef(config.ClientID), config)
+		if err != nil {
+			return err
+		}
+		r.setBucket(config.ClientID, limiter)
+		return nil
+	}
+
+	return reconfigure(limiter, config)
+}
+
+// clientSettings returns the settings to store for the client. On a plan,
+// the settings left at zero are stored as NULL so that they follow the
+// plan.
+func clientSettings(config model.ClientConfig) (capacity any, rate any, algorithm any, window any) {
+	if config.PlanID == "" {
+		return config.Capacity, config.RatePerSec, config.AlgorithmOrDefault(), config.WindowSec
+	}
+	if config.Capacity != 0 {
+		capacity = config.Capacity
+	}
+	if config.RatePerSec != 0 {
+		rate = config.RatePerSec
+	}
+	if config.Algorithm != "" {
+		algorithm = config.Algorithm
+	}
+	if config.WindowSec != 0 {
+		window = config.WindowSec
+	}
+	return capacity, rate, algorithm, window
+}
+
+// scanClient reads the clientColumns, followed by the extra columns of the
+// query.
+func scanClient(row rowScanner, extra ...any) (model.ClientConfig, error) {
+	var c model.ClientConfig
+	dest := append([]any{&c.ClientID, &c.Capacity, &c.RatePerSec, &c.Algorithm, &c.WindowSec, &c.OrgID, &c.PlanID}, extra...)
+	return c, row.Scan(dest...)
+}
+
+func reconfigure(limiter model.Limiter, config model.ClientConfig) error {
+	if err := model.CheckLimiter(config); err != nil {
+		return err
+	}
+	limiter.SetCapacity(config.Capacity)
+	limiter.SetRate(config.RatePerSec)
+	if windowed, ok := limiter.(model.WindowLimiter); ok {
+		windowed.SetWindow(config.Window())
+	}
+	return nil
+}
+
 func (r *UserRepoImpl) GetClients() error {
 	r.Mutex.Lock()
 	defer r.Mutex.Unlock()
 
 	log.Println("Attempting to retrieve clients from DB")
 
-	rows, err := r.db.Query("SELECT client_id, capacity, rate_per_sec FROM clients")
+	if err := r.loadOrgs(true); err != nil {
+		log.Printf("Failed to load organizations from DB: %v", err)
+		return err
+	}
+	if err := r.loadPlans(); err != nil {
+		log.Printf("Failed to load plans from DB: %v", err)
+		return err
+	}
+
+	rows, err := r.db.Query("SELECT " + clientColumns + ", c.tokens, c.last_refill FROM clients c")
 	if err != nil {
 		err = fmt.Errorf("failed to query clients: %w", err)
 		log.Printf("Failed to query clients from DB: %v", err)
@@ -128,19 +342,36 @@ func (r *UserRepoImpl) GetClients() error {
 	}
 	defer rows.Close()
 
-	newBuckets := make(map[string]*model.TokenBucket)
+	newBuckets := make(map[string]model.Limiter)
+	parents := make(map[string]string)
 	for rows.Next() {
-		var clientID string
-		var capacity int
-		var ratePerSec float64
-		if err := rows.Scan(&clientID, &capacity, &ratePerSec); err != nil {
+		var tokens sql.NullFloat64
+		var lastRefill sql.NullTime
+		config, err := scanClient(rows, &tokens, &lastRefill)
+		if err != nil {
 			err = fmt.Errorf("failed to scan client: %w", err)
 			log.Printf("Failed to scan client row: %v", err)
 			return err
 		}
+		effective, err := r.ResolveClient(config)
+		if err != nil {
+			log.Printf("Skipping client %s: %v", config.ClientID, err)
+			continue
+		}
 
-		newBuckets[clientID] = model.NewTokenBucket(clientID, capacity, ratePerSec)
-		log.Printf("Loaded client %s from DB", clientID)
+		limiter, err := r.newLimiter(clientRef(config.ClientID), effective)
+		if err != nil {
+			log.Printf("Skipping client %s: %v", config.ClientID, err)
+			continue
+		}
+		if tokens.Valid && lastRefill.Valid {
+			limiter.Restore(model.LimiterState{Tokens: tokens.Float64, LastRefill: lastRefill.Time})
+		}
+		newBuckets[config.ClientID] = limiter
+		if config.OrgID != "" {
+			parents[config.ClientID] = config.OrgID
+		}
+		log.Printf("Loaded client %s from DB", config.ClientID)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -149,11 +380,300 @@ func (r *UserRepoImpl) GetClients() error {
 		return err
 	}
 
+	r.bucketsMutex.Lock()
 	r.Buckets = newBuckets
-	log.Printf("Successfully retrieved and cached %d clients from DB", len(r.Buckets))
+	r.parents = parents
+	r.bucketsMutex.Unlock()
+	r.pruneOverrides()
+	log.Printf("Successfully retrieved and cached %d clients from DB", len(newBuckets))
+	return r.loadAllPolicies()
+}
+
+// SaveState writes the live token balance of every client in one
+// transaction, so a restart continues from where the process stopped.
+func (r *UserRepoImpl) SaveState() error {
+	r.Mutex.Lock()
+	states := make(map[string]model.LimiterState, len(r.Buckets))
+	for clientID, limiter := range r.Buckets {
+		if _, shared := limiter.(model.SharedLimiter); shared {
+			continue
+		}
+		states[clientID] = limiter.State()
+	}
+	orgStates := make(map[string]model.LimiterState, len(r.Orgs))
+	for orgID, limiter := range r.Orgs {
+		if _, shared := limiter.(model.SharedLimiter); shared {
+			continue
+		}
+		orgStates[orgID] = limiter.State()
+	}
+	r.Mutex.Unlock()
+	overrideStates := r.overrideStates()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin state transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := saveBalances(tx, "clients", "client_id", states); err != nil {
+		return err
+	}
+	if err := saveBalances(tx, "organizations", "org_id", orgStates); err != nil {
+		return err
+	}
+	if err := r.saveOverrideState(tx, overrideStates); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit state: %w", err)
+	}
+	log.Printf("Saved limiter state of %d clients and %d organizations", len(states), len(orgStates))
 	return nil
 }
 
-func (repo *UserRepoImpl) GetBuckets() map[string]*model.TokenBucket {
+// saveBalances writes the balances into the table with one UPDATE, so the
+// cost of a save does not grow with a round trip per row.
+func saveBalances(tx *sql.Tx, table string, key string, states map[string]model.LimiterState) error {
+	if len(states) == 0 {
+		return nil
+	}
+	ids, tokens, refills := stateArrays(states)
+	_, err := tx.Exec(`
+        UPDATE `+table+` t SET tokens = s.tokens, last_refill = s.last_refill
+        FROM unnest($1::TEXT[], $2::DOUBLE PRECISION[], $3::TIMESTAMPTZ[]) AS s (id, tokens, last_refill)
+        WHERE t.`+key+` = s.id`,
+		pq.Array(ids), pq.Array(tokens), pq.Array(refills),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save state of %s: %w", table, err)
+	}
+	return nil
+}
+
+// stateArrays splits the states into parallel arrays for unnest.
+func stateArrays(states map[string]model.LimiterState) ([]string, []float64, []string) {
+	ids := make([]string, 0, len(states))
+	tokens := make([]float64, 0, len(states))
+	refills := make([]string, 0, len(states))
+	for id, state := range states {
+		ids = append(ids, id)
+		tokens = append(tokens, state.Tokens)
+		refills = append(refills, state.LastRefill.Format(time.RFC3339Nano))
+	}
+	return ids, tokens, refills
+}
+
+func (repo *UserRepoImpl) GetBuckets() map[string]model.Limiter {
+	repo.bucketsMutex.RLock()
+	defer repo.bucketsMutex.RUnlock()
 	return repo.Buckets
 }
+
+// setBucket and deleteBucket must be called with r.Mutex held.
+func (r *UserRepoImpl) setBucket(clientID string, limiter model.Limiter) {
+	buckets := make(map[string]model.Limiter, len(r.Buckets)+1)
+	for id, l := range r.Buckets {
+		buckets[id] = l
+	}
+	buckets[clientID] = limiter
+
+	r.bucketsMutex.Lock()
+	r.Buckets = buckets
+	r.bucketsMutex.Unlock()
+}
+
+func (r *UserRepoImpl) deleteBucket(clientID string) {
+	r.deleteOverrides(clientID)
+	if _, ok := r.Buckets[clientID]; !ok {
+		return
+	}
+	buckets := make(map[string]model.Limiter, len(r.Buckets))
+	for id, l := range r.Buckets {
+		if id != clientID {
+			buckets[id] = l
+		}
+	}
+
+	r.bucketsMutex.Lock()
+	r.Buckets = buckets
+	r.bucketsMutex.Unlock()
+}
+
+// notify tells the other instances that a client changed. Failures are only
+// logged, the periodic SyncClients catches up with missed notifications.
+func (r *UserRepoImpl) notify(op string, clientID string) {
+	r.publish(ClientChange{Op: op, ClientID: clientID, Origin: r.instanceID})
+}
+
+func (r *UserRepoImpl) notifyOrg(op string, orgID string) {
+	r.publish(ClientChange{Op: op, OrgID: orgID, Origin: r.instanceID})
+}
+
+func (r *UserRepoImpl) publish(change ClientChange) {
+	payload, err := json.Marshal(change)
+	if err != nil {
+		log.Printf("Failed to encode change %+v: %v", change, err)
+		return
+	}
+	if _, err := r.db.Exec("SELECT pg_notify($1, $2)", ClientChangesChannel, string(payload)); err != nil {
+		log.Printf("Failed to notify change %+v: %v", change, err)
+	}
+}
+
+func (r *UserRepoImpl) GetClient(clientID string) (model.ClientConfig, bool, error) {
+	config, err := scanClient(r.db.QueryRow("SELECT "+clientColumns+" FROM clients c WHERE c.client_id = $1", clientID))
+	if err == sql.ErrNoRows {
+		return config, false, nil
+	}
+	if err != nil {
+		return config, false, fmt.Errorf("failed to load client %s: %w", clientID, err)
+	}
+	return config, true, nil
+}
+
+// ApplyChange applies a change made by another instance to the local
+// buckets. The config is re-read from the database rather than trusted from
+// the payload, so reordered notifications still converge.
+func (r *UserRepoImpl) ApplyChange(change ClientChange) error {
+	if change.Origin == r.instanceID {
+		return nil
+	}
+
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	if change.OrgID != "" {
+		return r.reloadOrg(change.OrgID)
+	}
+	if change.PlanID != "" {
+		return r.reloadPlan(change.PlanID)
+	}
+
+	config, found, err := r.GetClient(change.ClientID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		r.deleteBucket(change.ClientID)
+		r.setPolicies(change.ClientID, nil)
+		r.setParent(change.ClientID, "")
+		log.Printf("Client with ID %s removed by another instance", change.ClientID)
+		return nil
+	}
+	effective, err := r.ResolveClient(config)
+	if err != nil {
+		return err
+	}
+	if err := r.applyConfig(effective); err != nil {
+		return err
+	}
+	r.setParent(change.ClientID, config.OrgID)
+	if err := r.reloadPolicies(change.ClientID); err != nil {
+		return err
+	}
+	log.Printf("Client with ID %s updated by another instance", change.ClientID)
+	return nil
+}
+
+// SyncClients reconciles the local buckets with the clients table without
+// resetting the usage of clients whose config did not change.
+func (r *UserRepoImpl) SyncClients() error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	if err := r.loadOrgs(false); err != nil {
+		return err
+	}
+	if err := r.loadPlans(); err != nil {
+		return err
+	}
+
+	rows, err := r.db.Query("SELECT " + clientColumns + " FROM clients c")
+	if err != nil {
+		return fmt.Errorf("failed to query clients: %w", err)
+	}
+	defer rows.Close()
+
+	var configs []model.ClientConfig
+	for rows.Next() {
+		config, err := scanClient(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan client: %w", err)
+		}
+		configs = append(configs, config)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	buckets := make(map[string]model.Limiter, len(configs))
+	parents := make(map[string]string)
+	for _, config := range configs {
+		config, err := r.ResolveClient(config)
+		if err != nil {
+			log.Printf("Skipping client %s: %v", config.ClientID, err)
+			continue
+		}
+		limiter, exists := r.Buckets[config.ClientID]
+		if !exists || limiter.Algorithm() != config.AlgorithmOrDefault() {
+			limiter, err = r.newLimiter(clientRef(config.ClientID), config)
+			if err != nil {
+				log.Printf("Skipping client %s: %v", config.ClientID, err)
+				continue
+			}
+		} else if err := reconfigure(limiter, config); err != nil {
+			log.Printf("Skipping client %s: %v", config.ClientID, err)
+			continue
+		}
+		buckets[config.ClientID] = limiter
+		if config.OrgID != "" {
+			parents[config.ClientID] = config.OrgID
+		}
+	}
+
+	r.bucketsMutex.Lock()
+	r.Buckets = buckets
+	r.parents = parents
+	r.bucketsMutex.Unlock()
+	r.pruneOverrides()
+	log.Printf("Synchronized %d clients from DB", len(buckets))
+	return r.loadAllPolicies()
+}
+
+func (r *UserRepoImpl) ListClients(filter model.ClientFilter) ([]model.ClientConfig, int, error) {
+	// The algorithm filter matches the effective algorithm, which clients on
+	// a plan may inherit.
+	from := `FROM clients c LEFT JOIN plans p ON p.plan_id = c.plan_id
+		WHERE c.client_id LIKE $1 || '%' AND ($2 = '' OR COALESCE(c.algorithm, p.algorithm) = $2)
+		AND ($3 = '' OR c.org_id = $3) AND ($4 = '' OR c.plan_id = $4)`
+	prefix := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(filter.Prefix)
+
+	var total int
+	if err := r.db.QueryRow("SELECT COUNT(*) "+from, prefix, filter.Algorithm, filter.OrgID, filter.PlanID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count clients: %w", err)
+	}
+
+	rows, err := r.db.Query(
+		"SELECT "+clientColumns+" "+from+" ORDER BY c.client_id LIMIT $5 OFFSET $6",
+		prefix, filter.Algorithm, filter.OrgID, filter.PlanID, filter.Limit, filter.Offset,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query clients: %w", err)
+	}
+	defer rows.Close()
+
+	configs := make([]model.ClientConfig, 0, filter.Limit)
+	for rows.Next() {
+		config, err := scanClient(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan client: %w", err)
+		}
+		configs = append(configs, config)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating over rows: %w", err)
+	}
+	return configs, total, nil
+}
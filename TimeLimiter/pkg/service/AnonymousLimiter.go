@@ -0,0 +1,202 @@
+package service
+
+import (
+	"LoadBalancer/TimeLimiter/pkg/model"
+	"container/list"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RejectUnknown    = "reject"
+	AnonymousUnknown = "anonymous"
+)
+
+// ipv6PrefixBits is the prefix IPv6 clients are limited by. A single host
+// usually gets a whole /64, keying by the full address would let it rotate
+// through its prefix.
+const ipv6PrefixBits = 64
+
+type AnonymousConfig struct {
+	Capacity       int
+	RatePerSec     float64
+	TrustedProxies []*net.IPNet
+	IdleTimeout    time.Duration
+	MaxBuckets     int
+}
+
+// AnonymousLimiter limits requests of clients the limiter does not know by
+// their IPv4 address or IPv6 /64 prefix. Buckets idle for IdleTimeout are
+// evicted, and at most MaxBuckets are tracked: when a new address arrives
+// while the limiter is full, the least recently used bucket is dropped, so
+// the memory used does not depend on how many addresses send traffic.
+type AnonymousLimiter struct {
+	config AnonymousConfig
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	// lru orders the buckets from the most to the least recently used.
+	lru *list.List
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type anonymousBucket struct {
+	key      string
+	limiter  model.Limiter
+	lastSeen time.Time
+}
+
+func NewAnonymousLimiter(config AnonymousConfig) *AnonymousLimiter {
+	al := &AnonymousLimiter{
+		config:  config,
+		buckets: make(map[string]*list.Element),
+		lru:     list.New(),
+		stop:    make(chan struct{}),
+	}
+	go al.run()
+	return al
+}
+
+func (al *AnonymousLimiter) run() {
+	t := time.NewTicker(al.config.IdleTimeout / 2)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if n := al.evictIdle(); n > 0 {
+				log.Printf("Evicted %d idle anonymous buckets", n)
+			}
+		case <-al.stop:
+			return
+		}
+	}
+}
+
+func (al *AnonymousLimiter) Stop() {
+	al.stopOnce.Do(func() {
+		close(al.stop)
+	})
+}
+
+// Allow charges the cost to the bucket of the request's client address.
+func (al *AnonymousLimiter) Allow(r *http.Request, cost float64) model.Decision {
+	return al.bucket(bucketKey(al.ClientIP(r))).Take(cost)
+}
+
+// Adjust debits (positive delta) or refunds (negative delta) the bucket of
+// the request's client address. A bucket evicted since the request was
+// allowed is not recreated.
+func (al *AnonymousLimiter) Adjust(r *http.Request, delta float64) {
+	if delta == 0 {
+		return
+	}
+	al.mu.Lock()
+	e, ok := al.buckets[bucketKey(al.ClientIP(r))]
+	al.mu.Unlock()
+	if ok {
+		e.Value.(*anonymousBucket).limiter.Adjust(delta)
+	}
+}
+
+func (al *AnonymousLimiter) bucket(key string) model.Limiter {
+	now := time.Now()
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if e, ok := al.buckets[key]; ok {
+		b := e.Value.(*anonymousBucket)
+		b.lastSeen = now
+		al.lru.MoveToFront(e)
+		return b.limiter
+	}
+
+	for al.lru.Len() > 0 && al.lru.Len() >= al.config.MaxBuckets {
+		al.remove(al.lru.Back())
+	}
+	b := &anonymousBucket{
+		key:      key,
+		limiter:  model.NewTokenBucket("anonymous:"+key, al.config.Capacity, al.config.RatePerSec),
+		lastSeen: now,
+	}
+	al.buckets[key] = al.lru.PushFront(b)
+	return b.limiter
+}
+
+func (al *AnonymousLimiter) evictIdle() int {
+	cutoff := time.Now().Add(-al.config.IdleTimeout)
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	evicted := 0
+	for e := al.lru.Back(); e != nil && e.Value.(*anonymousBucket).lastSeen.Before(cutoff); e = al.lru.Back() {
+		al.remove(e)
+		evicted++
+	}
+	return evicted
+}
+
+// remove must be called with al.mu held.
+func (al *AnonymousLimiter) remove(e *list.Element) {
+	al.lru.Remove(e)
+	delete(al.buckets, e.Value.(*anonymousBucket).key)
+}
+
+// bucketKey is the address itself for IPv4 and its /64 prefix for IPv6.
+func bucketKey(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.To4() != nil {
+		return ip
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(ipv6PrefixBits, 128)), Mask: net.CIDRMask(ipv6PrefixBits, 128)}).String()
+}
+
+// ClientIP returns the address the request came from. X-Forwarded-For is
+// only honoured when the connection comes from a trusted proxy: the list is
+// read from the right and the first address that is not a trusted proxy is
+// the client, so entries prepended by the client itself are ignored.
+func (al *AnonymousLimiter) ClientIP(r *http.Request) string {
+	ip := remoteIP(r.RemoteAddr)
+	if !al.trusted(ip) {
+		return ip.String()
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !al.trusted(hop) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+func (al *AnonymousLimiter) trusted(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range al.config.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return net.ParseIP(host)
+}
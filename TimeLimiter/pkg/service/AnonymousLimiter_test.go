@@ -0,0 +1,55 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAnonymousLimiterAdjust(t *testing.T) {
+	tests := []struct {
+		name    string
+		client  string
+		taken   float64
+		adjust  float64
+		from    string
+		costs   []float64
+		allowed []bool
+	}{
+		{"refund frees tokens", "192.0.2.1:1000", 2, -1, "192.0.2.1:1000", []float64{1, 1}, []bool{true, false}},
+		{"debit uses tokens", "192.0.2.1:1000", 0, 1.5, "192.0.2.1:1000", []float64{0.5, 0.5}, []bool{true, false}},
+		{"same IPv6 prefix", "[2001:db8::1]:1000", 0, 2, "[2001:db8::2]:1000", []float64{1}, []bool{false}},
+		{"other address is not charged", "192.0.2.1:1000", 0, 2, "192.0.2.2:1000", []float64{2}, []bool{true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			al := NewAnonymousLimiter(AnonymousConfig{Capacity: 2, RatePerSec: 0.001, IdleTimeout: time.Hour, MaxBuckets: 10})
+			defer al.Stop()
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.client
+			al.Allow(r, tt.taken)
+
+			settled := httptest.NewRequest("GET", "/", nil)
+			settled.RemoteAddr = tt.from
+			al.Adjust(settled, tt.adjust)
+
+			for i, cost := range tt.costs {
+				if got := al.Allow(r, cost).Allowed; got != tt.allowed[i] {
+					t.Fatalf("request %d of %v: allowed = %v, want %v", i, cost, got, tt.allowed[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAnonymousLimiterAdjustDoesNotCreateBuckets(t *testing.T) {
+	al := NewAnonymousLimiter(AnonymousConfig{Capacity: 2, RatePerSec: 0.001, IdleTimeout: time.Hour, MaxBuckets: 10})
+	defer al.Stop()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	al.Adjust(r, 5)
+	if n := len(al.buckets); n != 0 {
+		t.Fatalf("buckets = %d, want 0", n)
+	}
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"LoadBalancer/TimeLimiter/pkg/model"
+	"LoadBalancer/TimeLimiter/pkg/repository"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	apiKeyPrefix   = "tlk_"
+	apiKeyBytes    = 32
+	apiKeyShownLen = len(apiKeyPrefix) + 8
+	ApiKeyHeader   = "X-API-Key"
+	bearerPrefix   = "Bearer "
+	// maxCachedKeys bounds the cache, which also holds unknown keys.
+	maxCachedKeys = 100000
+)
+
+// ApiKeyService issues API keys and authenticates requests by them. Keys
+// are cached for cacheTTL, so a key revoked on another instance stays
+// usable there for at most that long. Unknown keys are cached as well, so
+// repeating an invalid key does not reach the database every time.
+type ApiKeyService struct {
+	repo     repository.ApiKeyRepo
+	cacheTTL time.Duration
+
+	mu    sync.RWMutex
+	cache map[string]cachedKey
+}
+
+type cachedKey struct {
+	key      model.ApiKey
+	found    bool
+	loadedAt time.Time
+}
+
+func NewApiKeyService(repo repository.ApiKeyRepo, cacheTTL time.Duration) *ApiKeyService {
+	return &ApiKeyService{
+		repo:     repo,
+		cacheTTL: cacheTTL,
+		cache:    make(map[string]cachedKey),
+	}
+}
+
+// Authenticate looks the key up from the X-API-Key header or a bearer
+// Authorization header.
+func (ks *ApiKeyService) Authenticate(r *http.Request) (Identity, error) {
+	raw := requestApiKey(r)
+	if raw == "" {
+		return Identity{}, fmt.Errorf("%w: API key is required", ErrNoCredentials)
+	}
+
+	if !wellFormedApiKey(raw) {
+		return Identity{}, fmt.Errorf("%w: invalid API key", ErrUnauthenticated)
+	}
+	key, found, err := ks.lookup(hashApiKey(raw))
+	if err != nil {
+		return Identity{}, err
+	}
+	if !found || !key.Active(time.Now()) {
+		return Identity{}, fmt.Errorf("%w: invalid API key", ErrUnauthenticated)
+	}
+
+	// The key is the limiter's credential and is not passed on to backends.
+	r.Header.Del(ApiKeyHeader)
+	if strings.HasPrefix(r.Header.Get("Authorization"), bearerPrefix+apiKeyPrefix) {
+		r.Header.Del("Authorization")
+	}
+	return Identity{ClientID: key.ClientID}, nil
+}
+
+func requestApiKey(r *http.Request) string {
+	if key := r.Header.Get(ApiKeyHeader); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, bearerPrefix) {
+		return strings.TrimSpace(strings.TrimPrefix(auth, bearerPrefix))
+	}
+	return ""
+}
+
+func (ks *ApiKeyService) lookup(hash string) (model.ApiKey, bool, error) {
+	ks.mu.RLock()
+	cached, ok := ks.cache[hash]
+	ks.mu.RUnlock()
+	if ok && time.Since(cached.loadedAt) < ks.cacheTTL {
+		return cached.key, cached.found, nil
+	}
+
+	key, found, err := ks.repo.FindKey(hash)
+	if err != nil {
+		return key, false, err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if _, ok := ks.cache[hash]; !ok && len(ks.cache) >= maxCachedKeys {
+		ks.pruneCache()
+	}
+	if len(ks.cache) < maxCachedKeys {
+		ks.cache[hash] = cachedKey{key: key, found: found, loadedAt: time.Now()}
+	}
+	return key, found, nil
+}
+
+// pruneCache drops the expired entries. Must be called with ks.mu held.
+func (ks *ApiKeyService) pruneCache() {
+	for hash, cached := range ks.cache {
+		if time.Since(cached.loadedAt) >= ks.cacheTTL {
+			delete(ks.cache, hash)
+		}
+	}
+}
+
+// wellFormedApiKey reports whether raw looks like a key made by newApiKey,
+// anything else cannot exist and is rejected without a lookup.
+func wellFormedApiKey(raw string) bool {
+	if !strings.HasPrefix(raw, apiKeyPrefix) {
+		return false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(raw[len(apiKeyPrefix):])
+	return err == nil && len(b) == apiKeyBytes
+}
+
+// Issue creates a new key for the client. A zero ttl means the key does not
+// expire.
+func (ks *ApiKeyService) Issue(clientID string, ttl time.Duration) (model.IssuedKey, error) {
+	raw, err := newApiKey()
+	if err != nil {
+		return model.IssuedKey{}, err
+	}
+	key, err := ks.repo.CreateKey(clientID, hashApiKey(raw), raw[:apiKeyShownLen], expiry(ttl))
+	if err != nil {
+		return model.IssuedKey{}, err
+	}
+	return model.IssuedKey{ApiKey: key, Key: raw}, nil
+}
+
+// Rotate issues a new key and keeps the client's current keys valid for the
+// overlap only.
+func (ks *ApiKeyService) Rotate(clientID string, ttl time.Duration, overlap time.Duration) (model.IssuedKey, error) {
+	raw, err := newApiKey()
+	if err != nil {
+		return model.IssuedKey{}, err
+	}
+	key, err := ks.repo.RotateKey(clientID, hashApiKey(raw), raw[:apiKeyShownLen], expiry(ttl), time.Now().Add(overlap))
+	if err != nil {
+		return model.IssuedKey{}, err
+	}
+	ks.forget(func(k model.ApiKey) bool { return k.ClientID == clientID })
+	return model.IssuedKey{ApiKey: key, Key: raw}, nil
+}
+
+func (ks *ApiKeyService) Revoke(clientID string, keyID int64) error {
+	if err := ks.repo.RevokeKey(clientID, keyID); err != nil {
+		return err
+	}
+	ks.forget(func(k model.ApiKey) bool { return k.ID == keyID })
+	return nil
+}
+
+func (ks *ApiKeyService) List(clientID string) ([]model.ApiKey, error) {
+	return ks.repo.ListKeys(clientID)
+}
+
+// forget drops the matching keys from the cache so that changes made on
+// this instance apply immediately.
+func (ks *ApiKeyService) forget(match func(model.ApiKey) bool) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	for hash, cached := range ks.cache {
+		if match(cached.key) {
+			delete(ks.cache, hash)
+		}
+	}
+}
+
+func newApiKey() (string, error) {
+	b := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Failed to generate API key: %v", err)
+		return "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashApiKey(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func expiry(ttl time.Duration) *time.Time {
+	if ttl <= 0 {
+		return nil
+	}
+	t := time.Now().Add(ttl)
+	return &t
+}
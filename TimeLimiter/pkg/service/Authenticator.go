@@ -0,0 +1,69 @@
+package service
+
+import (
+	"LoadBalancer/TimeLimiter/pkg/model"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	QueryAuth  = "query"
+	ApiKeyAuth = "api_key"
+	JWTAuth    = "jwt"
+)
+
+var (
+	// ErrMissingClientID is returned in query mode when the request has no
+	// client_id parameter.
+	ErrMissingClientID = errors.New("client_id is required")
+	// ErrUnauthenticated is returned when the request carries no valid
+	// credentials.
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrNoCredentials is returned when the request carries no credentials
+	// at all, as opposed to invalid ones; it matches ErrUnauthenticated.
+	ErrNoCredentials = fmt.Errorf("%w: no credentials", ErrUnauthenticated)
+)
+
+// Identity is the client a request is made on behalf of, with the limits
+// its credentials override, if any.
+type Identity struct {
+	ClientID string
+	Override *model.LimitOverride
+}
+
+// Authenticator identifies the client of a request before it reaches the
+// limiter.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Identity, error)
+}
+
+// QueryAuthenticator trusts the client_id query parameter. It is only meant
+// for deployments where the callers are trusted.
+type QueryAuthenticator struct{}
+
+func (QueryAuthenticator) Authenticate(r *http.Request) (Identity, error) {
+	clientID := r.URL.Query().Get("client_id")
+	if clientID == "" {
+		return Identity{}, ErrMissingClientID
+	}
+	return Identity{ClientID: clientID}, nil
+}
+
+// NewAuthenticator picks the authenticator for the mode; jwt may be nil
+// unless the mode is JWTAuth.
+func NewAuthenticator(mode string, keys *ApiKeyService, jwt *JWTAuthenticator) (Authenticator, error) {
+	switch mode {
+	case QueryAuth:
+		return QueryAuthenticator{}, nil
+	case "", ApiKeyAuth:
+		return keys, nil
+	case JWTAuth:
+		if jwt == nil {
+			return nil, fmt.Errorf("JWT authentication is not configured")
+		}
+		return jwt, nil
+	default:
+		return nil, fmt.Errorf("unknown auth mode %q", mode)
+	}
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"LoadBalancer/TimeLimiter/pkg/repository"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ClientSyncService keeps the local buckets in line with client changes made
+// by other instances. It LISTENs for the notifications sent by UserRepoImpl
+// and falls back to a full resync periodically and after the listener
+// connection was re-established, since notifications sent meanwhile are lost.
+type ClientSyncService struct {
+	repo     repository.UserRepo
+	listener *pq.Listener
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewClientSyncService(databaseURL string, repo repository.UserRepo, interval time.Duration) (*ClientSyncService, error) {
+	listener := pq.NewListener(databaseURL, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Client sync listener: %v", err)
+		}
+	})
+	if err := listener.Listen(repository.ClientChangesChannel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	cs := &ClientSyncService{
+		repo:     repo,
+		listener: listener,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go cs.run()
+	return cs, nil
+}
+
+func (cs *ClientSyncService) run() {
+	defer close(cs.done)
+	t := time.NewTicker(cs.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case n := <-cs.listener.Notify:
+			if n == nil {
+				// pq sends nil after reconnecting.
+				log.Println("Client sync listener reconnected, resynchronizing")
+				cs.resync()
+				continue
+			}
+			cs.apply(n.Extra)
+		case <-t.C:
+			cs.resync()
+		case <-cs.stop:
+			return
+		}
+	}
+}
+
+func (cs *ClientSyncService) apply(payload string) {
+	var change repository.ClientChange
+	if err := json.Unmarshal([]byte(payload), &change); err != nil {
+		log.Printf("Client sync: malformed notification %q: %v", payload, err)
+		return
+	}
+	if err := cs.repo.ApplyChange(change); err != nil {
+		log.Printf("Client sync: failed to apply change of %s: %v", change.ClientID, err)
+	}
+}
+
+func (cs *ClientSyncService) resync() {
+	if err := cs.repo.SyncClients(); err != nil {
+		log.Printf("Client sync: full resync failed: %v", err)
+	}
+}
+
+func (cs *ClientSyncService) Stop() {
+	cs.stopOnce.Do(func() {
+		close(cs.stop)
+		<-cs.done
+		cs.listener.Close()
+	})
+}
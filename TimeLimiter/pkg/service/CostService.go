@@ -0,0 +1,61 @@
+package service
+
+import (
+	"LoadBalancer/TimeLimiter/pkg/model"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+const defaultRequestCost = 1
+
+type CostService struct {
+	rules          []model.CostRule
+	responseHeader string
+}
+
+func NewCostService(rules []model.CostRule, responseHeader string) *CostService {
+	return &CostService{
+		rules:          rules,
+		responseHeader: responseHeader,
+	}
+}
+
+// RequestCost returns the cost of the first matching rule, or 1.
+func (cs *CostService) RequestCost(r *http.Request) float64 {
+	for _, rule := range cs.rules {
+		if !rule.Match(r.Method, r.URL.Path) {
+			continue
+		}
+		if rule.Header != "" {
+			value := r.Header.Get(rule.Header)
+			if value == "" || (rule.HeaderValue != "" && value != rule.HeaderValue) {
+				continue
+			}
+		}
+		return rule.Cost
+	}
+	return defaultRequestCost
+}
+
+func (cs *CostService) ResponseHeader() string {
+	return cs.responseHeader
+}
+
+// ReportedCost parses the cost a backend reported for the request. Only
+// finite, non-negative values are accepted; ParseFloat also reads "NaN" and
+// "Inf", which would poison the bucket and the quota totals.
+func (cs *CostService) ReportedCost(h http.Header) (float64, bool) {
+	if cs.responseHeader == "" {
+		return 0, false
+	}
+	raw := h.Get(cs.responseHeader)
+	if raw == "" {
+		return 0, false
+	}
+	cost, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(cost) || math.IsInf(cost, 0) || cost < 0 {
+		return 0, false
+	}
+	return cost, true
+}
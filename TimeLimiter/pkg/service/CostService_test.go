@@ -0,0 +1,48 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCostServiceReportedCost(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		want   float64
+		wantOK bool
+	}{
+		{"integer", "3", 3, true},
+		{"fraction", "0.25", 0.25, true},
+		{"zero", "0", 0, true},
+		{"missing", "", 0, false},
+		{"not a number", "three", 0, false},
+		{"negative", "-1", 0, false},
+		{"NaN", "NaN", 0, false},
+		{"Inf", "Inf", 0, false},
+		{"+Inf", "+Inf", 0, false},
+		{"-Inf", "-Inf", 0, false},
+		{"overflow", "1e400", 0, false},
+	}
+	cs := NewCostService(nil, "X-Request-Cost")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			if tt.value != "" {
+				h.Set("X-Request-Cost", tt.value)
+			}
+			got, ok := cs.ReportedCost(h)
+			if got != tt.want || ok != tt.wantOK {
+				t.Fatalf("ReportedCost(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCostServiceReportedCostWithoutHeader(t *testing.T) {
+	h := http.Header{}
+	h.Set("X-Request-Cost", "5")
+	if _, ok := NewCostService(nil, "").ReportedCost(h); ok {
+		t.Fatal("a cost was read although no response header is configured")
+	}
+}
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"LoadBalancer/TimeLimiter/pkg/model"
+	"LoadBalancer/TimeLimiter/pkg/repository"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	LocalMode    = "local"
+	PostgresMode = "postgres"
+	LeaseMode    = "lease"
+)
+
+type DistributedConfig struct {
+	Mode         string
+	LeaseSize    float64
+	SyncInterval time.Duration
+}
+
+// DistributedLimiter shares a token bucket between instances through
+// Postgres. In postgres mode every request takes its tokens with one
+// atomic UPDATE, which is exact but costs a round trip. In lease mode an
+// instance takes a batch of LeaseSize tokens and serves requests from it
+// locally; unused tokens are handed back every SyncInterval. Larger leases
+// mean fewer queries but allow up to LeaseSize tokens per instance to be
+// held back from the others. If the database is unavailable the limiter
+// falls back to its local bucket.
+type DistributedLimiter struct {
+	ref     repository.BucketRef
+	manager *LeaseManager
+	local   model.Limiter
+
+	mu         sync.Mutex
+	leased     float64
+	leasedAt   time.Time
+	registered bool
+	lastGrant  repository.TokenGrant
+	hasGranted bool
+}
+
+func (d *DistributedLimiter) Algorithm() string {
+	return d.local.Algorithm()
+}
+
+func (d *DistributedLimiter) Shared() {}
+
+func (d *DistributedLimiter) Take(n float64) model.Decision {
+	if d.manager.config.Mode == PostgresMode {
+		return d.takeExact(n)
+	}
+	return d.takeLeased(n)
+}
+
+func (d *DistributedLimiter) takeExact(n float64) model.Decision {
+	grant, err := d.manager.repo.TakeTokens(d.ref, n, n)
+	if err != nil {
+		log.Printf("Shared limiter for %s unavailable, using local bucket: %v", d.ref, err)
+		return d.local.Take(n)
+	}
+	d.mu.Lock()
+	d.lastGrant, d.hasGranted = grant, true
+	d.mu.Unlock()
+	return grantDecision(grant, grant.Granted >= n, 0, n)
+}
+
+func (d *DistributedLimiter) takeLeased(n float64) model.Decision {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.leased < n {
+		want := math.Max(d.manager.config.LeaseSize, n)
+		grant, err := d.manager.repo.TakeTokens(d.ref, n-d.leased, want-d.leased)
+		if err != nil {
+			log.Printf("Shared limiter for %s unavailable, using local bucket: %v", d.ref, err)
+			return d.local.Take(n)
+		}
+		d.lastGrant, d.hasGranted = grant, true
+		if grant.Granted > 0 {
+			if d.leased == 0 {
+				d.leasedAt = time.Now()
+			}
+			if !d.registered {
+				d.registered = true
+				d.manager.register(d)
+			}
+			d.leased += grant.Granted
+		}
+	}
+
+	allowed := d.leased >= n
+	if allowed {
+		d.leased -= n
+	}
+	return grantDecision(d.lastGrant, allowed, d.leased, n)
+}
+
+// grantDecision builds a decision from the shared balance returned by the
+// database plus what this instance still holds locally.
+func grantDecision(grant repository.TokenGrant, allowed bool, held float64, n float64) model.Decision {
+	available := grant.Tokens + held
+	decision := model.Decision{
+		Allowed:   allowed,
+		Limit:     grant.Capacity,
+		Remaining: int(math.Max(0, math.Floor(available))),
+	}
+	if grant.RatePerSec > 0 {
+		deficit := math.Max(0, float64(grant.Capacity)-available)
+		decision.ResetAfter = time.Duration(deficit / grant.RatePerSec * float64(time.Second))
+		if !allowed {
+			decision.RetryAfter = time.Duration(math.Max(0, n-available) / grant.RatePerSec * float64(time.Second))
+		}
+	}
+	return decision
+}
+
+func (d *DistributedLimiter) Adjust(n float64) {
+	if err := d.manager.repo.ReturnTokens(d.ref, -n); err != nil {
+		log.Printf("Failed to adjust shared bucket of %s: %v", d.ref, err)
+		d.local.Adjust(n)
+	}
+}
+
+func (d *DistributedLimiter) Available() float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.hasGranted {
+		return d.local.Available()
+	}
+	return d.lastGrant.Tokens + d.leased
+}
+
+func (d *DistributedLimiter) State() model.LimiterState {
+	return d.local.State()
+}
+
+// Restore is a no-op, the shared state already lives in the database.
+func (d *DistributedLimiter) Restore(state model.LimiterState) {}
+
+func (d *DistributedLimiter) SetCapacity(capacity int) {
+	d.local.SetCapacity(capacity)
+}
+
+func (d *DistributedLimiter) SetRate(ratePerSec float64) {
+	d.local.SetRate(ratePerSec)
+}
+
+// releaseLease hands unused leased tokens back if the lease is older than
+// maxAge. A limiter without a lease is dropped from the manager until it
+// leases again.
+func (d *DistributedLimiter) releaseLease(maxAge time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.leased > 0 {
+		if time.Since(d.leasedAt) < maxAge {
+			return
+		}
+		if err := d.manager.repo.ReturnTokens(d.ref, d.leased); err != nil {
+			log.Printf("Failed to return leased tokens of %s: %v", d.ref, err)
+			return
+		}
+		d.leased = 0
+	}
+	d.registered = false
+	d.manager.unregister(d)
+}
+
+// LeaseManager creates the distributed limiters and reconciles their leases.
+type LeaseManager struct {
+	config DistributedConfig
+	repo   repository.TokenStateRepo
+	mu     sync.Mutex
+	leases map[*DistributedLimiter]struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func NewLeaseManager(config DistributedConfig, repo repository.TokenStateRepo) (*LeaseManager, error) {
+	switch config.Mode {
+	case "", LocalMode, PostgresMode, LeaseMode:
+	default:
+		return nil, fmt.Errorf("unknown rate limit mode %q", config.Mode)
+	}
+	if config.LeaseSize < 1 {
+		config.LeaseSize = 1
+	}
+	if config.SyncInterval <= 0 {
+		config.SyncInterval = time.Second
+	}
+
+	m := &LeaseManager{
+		config: config,
+		repo:   repo,
+		leases: make(map[*DistributedLimiter]struct{}),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if config.Mode == LeaseMode {
+		go m.reconcile()
+	} else {
+		close(m.done)
+	}
+	return m, nil
+}
+
+// NewLimiter is the limiter factory for the repository. Only token buckets
+// can be shared, other algorithms stay local to the instance.
+func (m *LeaseManager) NewLimiter(ref repository.BucketRef, config model.ClientConfig) (model.Limiter, error) {
+	local, err := model.NewLimiter(config)
+	if err != nil {
+		return nil, err
+	}
+	if m.config.Mode == "" || m.config.Mode == LocalMode {
+		return local, nil
+	}
+	if local.Algorithm() != model.TokenBucketAlgorithm {
+		log.Printf("Algorithm %s of %s is not shared between instances", local.Algorithm(), ref)
+		return local, nil
+	}
+	return &DistributedLimiter{
+		ref:     ref,
+		manager: m,
+		local:   local,
+	}, nil
+}
+
+func (m *LeaseManager) register(d *DistributedLimiter) {
+	m.mu.Lock()
+	m.leases[d] = struct{}{}
+	m.mu.Unlock()
+}
+
+func (m *LeaseManager) unregister(d *DistributedLimiter) {
+	m.mu.Lock()
+	delete(m.leases, d)
+	m.mu.Unlock()
+}
+
+func (m *LeaseManager) reconcile() {
+	defer close(m.done)
+	t := time.NewTicker(m.config.SyncInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			m.release(m.config.SyncInterval)
+		case <-m.stop:
+			m.release(0)
+			return
+		}
+	}
+}
+
+func (m *LeaseManager) release(maxAge time.Duration) {
+	m.mu.Lock()
+	limiters := make([]*DistributedLimiter, 0, len(m.leases))
+	for d := range m.leases {
+		limiters = append(limiters, d)
+	}
+	m.mu.Unlock()
+
+	for _, d := range limiters {
+		d.releaseLease(maxAge)
+	}
+}
+
+// Close returns every outstanding lease to the database.
+func (m *LeaseManager) Close() {
+	if m.config.Mode == LeaseMode {
+		close(m.stop)
+	}
+	<-m.done
+}
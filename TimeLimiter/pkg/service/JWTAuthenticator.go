@@ -0,0 +1,519 @@
+package service
+
+import (
+	"LoadBalancer/TimeLimiter/pkg/model"
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksRefetchInterval limits how often tokens with an unknown kid can make
+// the authenticator fetch the key set again, whether the fetch succeeds or
+// not.
+const jwksRefetchInterval = time.Minute
+
+type JWTConfig struct {
+	JWKSFile        string
+	JWKSURL         string
+	Audience        string
+	Issuer          string
+	ClientIDClaim   string
+	CapacityClaim   string
+	RateClaim       string
+	Leeway          time.Duration
+	RefreshInterval time.Duration
+}
+
+// JWTAuthenticator identifies clients by a bearer JWT signed with RS256,
+// ES256 or HS256. Keys come from a JWKS document (RSA, EC P-256 and oct
+// keys), read from a file or URL and reloaded every RefreshInterval.
+type JWTAuthenticator struct {
+	config JWTConfig
+	client *http.Client
+
+	mu   sync.RWMutex
+	keys []jwk
+	// lastAttempt is when the last fetch started, refreshing is set while
+	// one is running.
+	lastAttempt time.Time
+	refreshing  bool
+	refetches   sync.WaitGroup
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+type jwk struct {
+	kid string
+	alg string
+	key any
+}
+
+func NewJWTAuthenticator(config JWTConfig) (*JWTAuthenticator, error) {
+	if (config.JWKSFile == "") == (config.JWKSURL == "") {
+		return nil, fmt.Errorf("exactly one of the JWKS file or URL is required")
+	}
+	if config.ClientIDClaim == "" {
+		config.ClientIDClaim = "sub"
+	}
+
+	ja := &JWTAuthenticator{
+		config:      config,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+		lastAttempt: time.Now(),
+	}
+	if err := ja.refresh(); err != nil {
+		return nil, err
+	}
+	if config.RefreshInterval > 0 {
+		go ja.run()
+	} else {
+		close(ja.done)
+	}
+	return ja, nil
+}
+
+func (ja *JWTAuthenticator) run() {
+	defer close(ja.done)
+	t := time.NewTicker(ja.config.RefreshInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if !ja.beginRefresh(0) {
+				continue
+			}
+			if err := ja.refresh(); err != nil {
+				log.Printf("Failed to reload JWKS, keeping the previous keys: %v", err)
+			}
+			ja.endRefresh()
+		case <-ja.stop:
+			return
+		}
+	}
+}
+
+func (ja *JWTAuthenticator) Stop() {
+	ja.stopOnce.Do(func() {
+		close(ja.stop)
+		<-ja.done
+		ja.refetches.Wait()
+	})
+}
+
+// beginRefresh claims the right to fetch the key set. It fails while
+// another fetch is running or if the last one started less than minAge ago.
+func (ja *JWTAuthenticator) beginRefresh(minAge time.Duration) bool {
+	ja.mu.Lock()
+	defer ja.mu.Unlock()
+	if ja.refreshing || time.Since(ja.lastAttempt) < minAge {
+		return false
+	}
+	ja.refreshing = true
+	ja.lastAttempt = time.Now()
+	return true
+}
+
+func (ja *JWTAuthenticator) endRefresh() {
+	ja.mu.Lock()
+	ja.refreshing = false
+	ja.mu.Unlock()
+}
+
+// refetch reloads the key set in the background for a token with an
+// unknown kid. The request that triggered it is not held up; it fails with
+// the current keys and a retry succeeds once the new key has arrived.
+func (ja *JWTAuthenticator) refetch(kid string) {
+	if !ja.beginRefresh(jwksRefetchInterval) {
+		return
+	}
+	ja.refetches.Add(1)
+	go func() {
+		defer ja.refetches.Done()
+		defer ja.endRefresh()
+		if err := ja.refresh(); err != nil {
+			log.Printf("Failed to reload JWKS for kid %q: %v", kid, err)
+		}
+	}()
+}
+
+func (ja *JWTAuthenticator) refresh() error {
+	var data []byte
+	var err error
+	if ja.config.JWKSFile != "" {
+		data, err = os.ReadFile(ja.config.JWKSFile)
+	} else {
+		data, err = ja.fetch(ja.config.JWKSURL)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS: %w", err)
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+	ja.mu.Lock()
+	ja.keys = keys
+	ja.mu.Unlock()
+	log.Printf("Loaded %d JWKS keys", len(keys))
+	return nil
+}
+
+func (ja *JWTAuthenticator) fetch(url string) ([]byte, error) {
+	resp, err := ja.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+func (ja *JWTAuthenticator) Authenticate(r *http.Request) (Identity, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, bearerPrefix) {
+		return Identity{}, fmt.Errorf("%w: bearer token is required", ErrNoCredentials)
+	}
+
+	claims, err := ja.verify(strings.TrimSpace(strings.TrimPrefix(auth, bearerPrefix)), time.Now())
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+
+	clientID, ok := claimString(claims, ja.config.ClientIDClaim)
+	if !ok || clientID == "" {
+		return Identity{}, fmt.Errorf("%w: token has no %s claim", ErrUnauthenticated, ja.config.ClientIDClaim)
+	}
+	identity := Identity{ClientID: clientID}
+
+	var override model.LimitOverride
+	if capacity, ok := claimNumber(claims, ja.config.CapacityClaim); ok && capacity > 0 {
+		override.Capacity = int(math.Min(capacity, math.MaxInt32))
+	}
+	if rate, ok := claimNumber(claims, ja.config.RateClaim); ok && rate > 0 {
+		override.RatePerSec = rate
+	}
+	if override != (model.LimitOverride{}) {
+		identity.Override = &override
+	}
+	return identity, nil
+}
+
+// verify checks the signature and the registered claims and returns all
+// claims of the token.
+func (ja *JWTAuthenticator) verify(token string, now time.Time) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	if !ja.verifySignature(header.Alg, header.Kid, signed, signature) {
+		return nil, errors.New("invalid token signature")
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if err := ja.checkClaims(claims, now); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verifySignature tries the keys matching the kid, or every key if the
+// token has none. An unknown kid triggers a background reload of the key
+// set, so keys added by the identity provider are picked up before the next
+// refresh.
+func (ja *JWTAuthenticator) verifySignature(alg string, kid string, signed []byte, signature []byte) bool {
+	ja.mu.RLock()
+	keys := ja.keys
+	ja.mu.RUnlock()
+
+	if kid != "" && !hasKid(keys, kid) {
+		ja.refetch(kid)
+		return false
+	}
+
+	for _, k := range keys {
+		if kid != "" && k.kid != kid {
+			continue
+		}
+		if k.alg != "" && k.alg != alg {
+			continue
+		}
+		if verifyWithKey(alg, k.key, signed, signature) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasKid(keys []jwk, kid string) bool {
+	for _, k := range keys {
+		if k.kid == kid {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyWithKey only accepts the algorithm that belongs to the key type, so
+// e.g. an RSA public key can never be used as an HMAC secret.
+func verifyWithKey(alg string, key any, signed []byte, signature []byte) bool {
+	digest := sha256.Sum256(signed)
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(pub, digest[:], r, s)
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	default:
+		return false
+	}
+}
+
+func (ja *JWTAuthenticator) checkClaims(claims map[string]any, now time.Time) error {
+	leeway := ja.config.Leeway
+	exp, ok := claimNumber(claims, "exp")
+	if !ok {
+		return errors.New("token has no exp claim")
+	}
+	if now.After(unixTime(exp).Add(leeway)) {
+		return errors.New("token is expired")
+	}
+	if nbf, ok := claimNumber(claims, "nbf"); ok && now.Add(leeway).Before(unixTime(nbf)) {
+		return errors.New("token is not valid yet")
+	}
+	if ja.config.Issuer != "" {
+		if iss, _ := claimString(claims, "iss"); iss != ja.config.Issuer {
+			return errors.New("unexpected token issuer")
+		}
+	}
+	if ja.config.Audience != "" && !hasAudience(claims["aud"], ja.config.Audience) {
+		return errors.New("token is not meant for this audience")
+	}
+	return nil
+}
+
+// hasAudience accepts aud as a single string or an array of strings.
+func hasAudience(aud any, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []any:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func unixTime(seconds float64) time.Time {
+	sec, frac := math.Modf(seconds)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
+
+// claim resolves a claim name, dots select nested objects, e.g.
+// "limits.capacity".
+func claim(claims map[string]any, name string) (any, bool) {
+	if name == "" {
+		return nil, false
+	}
+	var value any = claims
+	for _, part := range strings.Split(name, ".") {
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = obj[part]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func claimString(claims map[string]any, name string) (string, bool) {
+	value, ok := claim(claims, name)
+	if !ok {
+		return "", false
+	}
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	}
+	return "", false
+}
+
+func claimNumber(claims map[string]any, name string) (float64, bool) {
+	value, ok := claim(claims, name)
+	if !ok {
+		return 0, false
+	}
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := json.Number(v).Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func parseJWKS(data []byte) ([]jwk, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	keys := make([]jwk, 0, len(set.Keys))
+	for i, raw := range set.Keys {
+		if raw.Use != "" && raw.Use != "sig" {
+			continue
+		}
+		var key any
+		var err error
+		switch raw.Kty {
+		case "RSA":
+			key, err = rsaKey(raw.N, raw.E)
+		case "EC":
+			key, err = ecKey(raw.Crv, raw.X, raw.Y)
+		case "oct":
+			key, err = base64.RawURLEncoding.DecodeString(raw.K)
+		default:
+			err = fmt.Errorf("unsupported key type %q", raw.Kty)
+		}
+		if err != nil {
+			log.Printf("Skipping JWKS key %d (kid %q): %v", i, raw.Kid, err)
+			continue
+		}
+		keys = append(keys, jwk{kid: raw.Kid, alg: raw.Alg, key: key})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no usable keys")
+	}
+	return keys, nil
+}
+
+func rsaKey(n string, e string) (*rsa.PublicKey, error) {
+	nb, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	eb, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+	exponent := new(big.Int).SetBytes(eb)
+	if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > math.MaxInt32 {
+		return nil, errors.New("invalid exponent")
+	}
+	modulus := new(big.Int).SetBytes(nb)
+	if modulus.BitLen() < 2048 {
+		return nil, errors.New("RSA keys shorter than 2048 bits are not accepted")
+	}
+	return &rsa.PublicKey{N: modulus, E: int(exponent.Int64())}, nil
+}
+
+func ecKey(crv string, x string, y string) (*ecdsa.PublicKey, error) {
+	if crv != "P-256" {
+		return nil, fmt.Errorf("unsupported curve %q", crv)
+	}
+	xb, err := base64.RawURLEncoding.DecodeString(x)
+	if err != nil || len(xb) != 32 {
+		return nil, errors.New("invalid x coordinate")
+	}
+	yb, err := base64.RawURLEncoding.DecodeString(y)
+	if err != nil || len(yb) != 32 {
+		return nil, errors.New("invalid y coordinate")
+	}
+	// NewPublicKey rejects points that are not on the curve.
+	if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, xb...), yb...)); err != nil {
+		return nil, fmt.Errorf("invalid EC point: %w", err)
+	}
+	return &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(xb),
+		Y:     new(big.Int).SetBytes(yb),
+	}, nil
+}
@@ -0,0 +1,404 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var b64 = base64.RawURLEncoding
+
+type testKeys struct {
+	rsa    *rsa.PrivateKey
+	ec     *ecdsa.PrivateKey
+	secret []byte
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKeys{rsa: rsaKey, ec: ecKey, secret: []byte("0123456789abcdef0123456789abcdef")}
+}
+
+// jwks publishes the keys under the kids "rsa", "ec" and "hmac".
+func (k testKeys) jwks() map[string]any {
+	return map[string]any{"keys": []map[string]any{
+		{
+			"kty": "RSA", "kid": "rsa",
+			"n": b64.EncodeToString(k.rsa.N.Bytes()),
+			"e": b64.EncodeToString(big.NewInt(int64(k.rsa.E)).Bytes()),
+		},
+		{
+			"kty": "EC", "kid": "ec", "crv": "P-256",
+			"x": b64.EncodeToString(k.ec.X.FillBytes(make([]byte, 32))),
+			"y": b64.EncodeToString(k.ec.Y.FillBytes(make([]byte, 32))),
+		},
+		{"kty": "oct", "kid": "hmac", "k": b64.EncodeToString(k.secret)},
+	}}
+}
+
+func writeJWKS(t *testing.T, jwks any) string {
+	t.Helper()
+	data, err := json.Marshal(jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// signToken builds a token with the given header and claims. The signature
+// is made with key for alg, whatever key the header's kid names.
+func signToken(t *testing.T, header map[string]any, claims map[string]any, alg string, key any) string {
+	t.Helper()
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(h) + "." + b64.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch alg {
+	case "RS256":
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case "HS256":
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+	return signed + "." + b64.EncodeToString(signature)
+}
+
+func newTestJWTAuthenticator(t *testing.T, keys testKeys, config JWTConfig) *JWTAuthenticator {
+	t.Helper()
+	config.JWKSFile = writeJWKS(t, keys.jwks())
+	ja, err := NewJWTAuthenticator(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ja.Stop)
+	return ja
+}
+
+func TestJWTVerifySignature(t *testing.T) {
+	keys := newTestKeys(t)
+	ja := newTestJWTAuthenticator(t, keys, JWTConfig{})
+	now := time.Now()
+	claims := map[string]any{"sub": "client", "exp": now.Add(time.Hour).Unix()}
+	// The RSA public key in the form an attacker would use as an HMAC secret.
+	rsaPublic := keys.rsa.N.Bytes()
+
+	tests := []struct {
+		name    string
+		header  map[string]any
+		alg     string
+		key     any
+		wantErr bool
+	}{
+		{"RS256", map[string]any{"alg": "RS256", "kid": "rsa"}, "RS256", keys.rsa, false},
+		{"ES256", map[string]any{"alg": "ES256", "kid": "ec"}, "ES256", keys.ec, false},
+		{"HS256", map[string]any{"alg": "HS256", "kid": "hmac"}, "HS256", keys.secret, false},
+		{"no kid tries every key", map[string]any{"alg": "ES256"}, "ES256", keys.ec, false},
+		{"HS256 with the RSA public key as secret", map[string]any{"alg": "HS256", "kid": "rsa"}, "HS256", rsaPublic, true},
+		{"HS256 with the RSA public key and no kid", map[string]any{"alg": "HS256"}, "HS256", rsaPublic, true},
+		{"alg of another key type", map[string]any{"alg": "RS256", "kid": "hmac"}, "RS256", keys.rsa, true},
+		{"alg none", map[string]any{"alg": "none", "kid": "rsa"}, "none", nil, true},
+		{"unsupported alg", map[string]any{"alg": "RS512", "kid": "rsa"}, "RS256", keys.rsa, true},
+		{"kid of another key", map[string]any{"alg": "RS256", "kid": "ec"}, "RS256", keys.rsa, true},
+		{"unknown kid", map[string]any{"alg": "RS256", "kid": "other"}, "RS256", keys.rsa, true},
+		{"wrong secret", map[string]any{"alg": "HS256", "kid": "hmac"}, "HS256", []byte("guess"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := signToken(t, tt.header, claims, tt.alg, tt.key)
+			if _, err := ja.verify(token, now); (err != nil) != tt.wantErr {
+				t.Fatalf("verify() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWTVerifyKeyAlg(t *testing.T) {
+	keys := newTestKeys(t)
+	jwks := keys.jwks()
+	jwks["keys"].([]map[string]any)[2]["alg"] = "HS384"
+	ja, err := NewJWTAuthenticator(JWTConfig{JWKSFile: writeJWKS(t, jwks)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ja.Stop()
+
+	now := time.Now()
+	token := signToken(t, map[string]any{"alg": "HS256", "kid": "hmac"},
+		map[string]any{"sub": "client", "exp": now.Add(time.Hour).Unix()}, "HS256", keys.secret)
+	if _, err := ja.verify(token, now); err == nil {
+		t.Fatal("a key published for another alg was accepted")
+	}
+}
+
+func TestJWTVerifyClaims(t *testing.T) {
+	keys := newTestKeys(t)
+	ja := newTestJWTAuthenticator(t, keys, JWTConfig{
+		Audience: "api",
+		Issuer:   "https://issuer.example",
+		Leeway:   30 * time.Second,
+	})
+	now := time.Unix(1700000000, 0)
+	valid := func(changes map[string]any) map[string]any {
+		claims := map[string]any{
+			"sub": "client",
+			"iss": "https://issuer.example",
+			"aud": "api",
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		for name, value := range changes {
+			if value == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		claims  map[string]any
+		wantErr bool
+	}{
+		{"valid", valid(nil), false},
+		{"no exp", valid(map[string]any{"exp": nil}), true},
+		{"expired", valid(map[string]any{"exp": now.Add(-time.Minute).Unix()}), true},
+		{"expired within the leeway", valid(map[string]any{"exp": now.Add(-10 * time.Second).Unix()}), false},
+		{"fractional exp", valid(map[string]any{"exp": float64(now.Unix()) + 0.5}), false},
+		{"exp as a string", valid(map[string]any{"exp": "not a number"}), true},
+		{"not valid yet", valid(map[string]any{"nbf": now.Add(time.Minute).Unix()}), true},
+		{"nbf within the leeway", valid(map[string]any{"nbf": now.Add(10 * time.Second).Unix()}), false},
+		{"nbf in the past", valid(map[string]any{"nbf": now.Add(-time.Hour).Unix()}), false},
+		{"wrong audience", valid(map[string]any{"aud": "other"}), true},
+		{"no audience", valid(map[string]any{"aud": nil}), true},
+		{"audience in a list", valid(map[string]any{"aud": []string{"other", "api"}}), false},
+		{"audience missing from a list", valid(map[string]any{"aud": []string{"other"}}), true},
+		{"wrong issuer", valid(map[string]any{"iss": "https://evil.example"}), true},
+		{"no issuer", valid(map[string]any{"iss": nil}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := signToken(t, map[string]any{"alg": "RS256", "kid": "rsa"}, tt.claims, "RS256", keys.rsa)
+			if _, err := ja.verify(token, now); (err != nil) != tt.wantErr {
+				t.Fatalf("verify() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWTVerifyMalformed(t *testing.T) {
+	keys := newTestKeys(t)
+	ja := newTestJWTAuthenticator(t, keys, JWTConfig{})
+	now := time.Now()
+	token := signToken(t, map[string]any{"alg": "HS256", "kid": "hmac"},
+		map[string]any{"sub": "client", "exp": now.Add(time.Hour).Unix()}, "HS256", keys.secret)
+	tampered := signToken(t, map[string]any{"alg": "HS256", "kid": "hmac"},
+		map[string]any{"sub": "admin", "exp": now.Add(time.Hour).Unix()}, "HS256", keys.secret)
+	parts := strings.Split(token, ".")
+	tamperedParts := strings.Split(tampered, ".")
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"two segments", "a.b"},
+		{"four segments", token + ".x"},
+		{"header is not base64", "!!." + parts[1] + "." + parts[2]},
+		{"signature is not base64", parts[0] + "." + parts[1] + ".!!"},
+		{"claims swapped", parts[0] + "." + tamperedParts[1] + "." + parts[2]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ja.verify(tt.token, now); err == nil {
+				t.Fatal("malformed token was accepted")
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	keys := newTestKeys(t)
+	ja := newTestJWTAuthenticator(t, keys, JWTConfig{
+		ClientIDClaim: "client.id",
+		CapacityClaim: "limits.capacity",
+		RateClaim:     "limits.rate",
+	})
+	exp := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name         string
+		auth         string
+		claims       map[string]any
+		wantErr      error
+		wantClientID string
+		wantCapacity int
+		wantRate     float64
+	}{
+		{name: "no header", wantErr: ErrNoCredentials},
+		{name: "not a bearer token", auth: "Basic dXNlcjpwYXNz", wantErr: ErrNoCredentials},
+		{name: "invalid token", auth: "Bearer x.y.z", wantErr: ErrUnauthenticated},
+		{
+			name:         "nested client id",
+			claims:       map[string]any{"client": map[string]any{"id": "c1"}, "exp": exp},
+			wantClientID: "c1",
+		},
+		{
+			name:    "no client id",
+			claims:  map[string]any{"sub": "c1", "exp": exp},
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name: "limit claims",
+			claims: map[string]any{
+				"client": map[string]any{"id": "c1"},
+				"limits": map[string]any{"capacity": 50, "rate": 2.5},
+				"exp":    exp,
+			},
+			wantClientID: "c1",
+			wantCapacity: 50,
+			wantRate:     2.5,
+		},
+		{
+			name: "non-positive limit claims are ignored",
+			claims: map[string]any{
+				"client": map[string]any{"id": "c1"},
+				"limits": map[string]any{"capacity": -1, "rate": 0},
+				"exp":    exp,
+			},
+			wantClientID: "c1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			auth := tt.auth
+			if tt.claims != nil {
+				auth = "Bearer " + signToken(t, map[string]any{"alg": "ES256", "kid": "ec"}, tt.claims, "ES256", keys.ec)
+			}
+			if auth != "" {
+				r.Header.Set("Authorization", auth)
+			}
+
+			identity, err := ja.Authenticate(r)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Authenticate() = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if identity.ClientID != tt.wantClientID {
+				t.Errorf("client ID = %q, want %q", identity.ClientID, tt.wantClientID)
+			}
+			var capacity int
+			var rate float64
+			if identity.Override != nil {
+				capacity, rate = identity.Override.Capacity, identity.Override.RatePerSec
+			}
+			if capacity != tt.wantCapacity || rate != tt.wantRate {
+				t.Errorf("override = %d, %v; want %d, %v", capacity, rate, tt.wantCapacity, tt.wantRate)
+			}
+		})
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	keys := newTestKeys(t)
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := keys.jwks()["keys"].([]map[string]any)
+	offCurve := map[string]any{
+		"kty": "EC", "kid": "bad", "crv": "P-256",
+		"x": b64.EncodeToString(make([]byte, 32)),
+		"y": b64.EncodeToString(append(make([]byte, 31), 1)),
+	}
+
+	tests := []struct {
+		name     string
+		keys     []map[string]any
+		wantKids []string
+	}{
+		{"all key types", valid, []string{"rsa", "ec", "hmac"}},
+		{"encryption keys are skipped", []map[string]any{
+			{"kty": "oct", "kid": "enc", "use": "enc", "k": "c2VjcmV0"},
+			valid[2],
+		}, []string{"hmac"}},
+		{"short RSA keys are skipped", []map[string]any{
+			{"kty": "RSA", "kid": "weak", "n": b64.EncodeToString(weak.N.Bytes()), "e": "AQAB"},
+			valid[0],
+		}, []string{"rsa"}},
+		{"points off the curve are skipped", []map[string]any{offCurve, valid[1]}, []string{"ec"}},
+		{"other curves are skipped", []map[string]any{
+			{"kty": "EC", "kid": "p384", "crv": "P-384", "x": "AA", "y": "AA"},
+			valid[1],
+		}, []string{"ec"}},
+		{"unknown key types are skipped", []map[string]any{{"kty": "OKP", "kid": "ed"}, valid[2]}, []string{"hmac"}},
+		{"no usable keys", []map[string]any{{"kty": "OKP", "kid": "ed"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := json.Marshal(map[string]any{"keys": tt.keys})
+			parsed, err := parseJWKS(data)
+			if tt.wantKids == nil {
+				if err == nil {
+					t.Fatalf("parseJWKS() = %d keys, want an error", len(parsed))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(parsed) != len(tt.wantKids) {
+				t.Fatalf("parsed %d keys, want %v", len(parsed), tt.wantKids)
+			}
+			for i, k := range parsed {
+				if k.kid != tt.wantKids[i] {
+					t.Errorf("key %d has kid %q, want %q", i, k.kid, tt.wantKids[i])
+				}
+			}
+		})
+	}
+}
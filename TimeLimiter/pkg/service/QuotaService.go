@@ -0,0 +1,385 @@
+package service
+
+import (
+	"LoadBalancer/TimeLimiter/pkg/model"
+	"LoadBalancer/TimeLimiter/pkg/repository"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// quotaRetention is how long usage buckets are kept, a bit more than the
+// longest window.
+const quotaRetention = 62 * 24 * time.Hour
+
+// QuotaService enforces the long-period quotas. Usage is counted in memory
+// and written to Postgres in batches every flush interval; after each flush
+// the totals of all instances are read back, so instances see each other's
+// usage with a delay of about one interval.
+type QuotaService struct {
+	repo     repository.QuotaRepo
+	interval time.Duration
+
+	// syncMu keeps a flush and a reload from interleaving, otherwise the
+	// reload could read the totals while flushed usage is not yet stored.
+	syncMu sync.Mutex
+
+	mu         sync.Mutex
+	quotas     map[string][]*quotaState
+	lastPruned time.Time
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+type quotaState struct {
+	quota model.Quota
+	loc   *time.Location
+
+	// committed is the stored usage since `since`, pending is what this
+	// instance counted since the last flush, by bucket. flushed is what was
+	// written since committed was last read; it keeps counting until the
+	// next reload, which sees it in the stored totals.
+	since     time.Time
+	committed float64
+	pending   map[time.Time]float64
+	flushed   map[time.Time]float64
+}
+
+func NewQuotaService(repo repository.QuotaRepo, interval time.Duration) (*QuotaService, error) {
+	qs := &QuotaService{
+		repo:     repo,
+		interval: interval,
+		quotas:   make(map[string][]*quotaState),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := qs.Reload(); err != nil {
+		return nil, fmt.Errorf("failed to load quotas from DB: %w", err)
+	}
+	go qs.run()
+	return qs, nil
+}
+
+func (qs *QuotaService) run() {
+	defer close(qs.done)
+	t := time.NewTicker(qs.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := qs.flush(); err != nil {
+				log.Printf("Failed to flush quota usage: %v", err)
+			}
+			if err := qs.Reload(); err != nil {
+				log.Printf("Failed to refresh quotas: %v", err)
+			}
+		case <-qs.stop:
+			return
+		}
+	}
+}
+
+// Reserve charges the cost to every quota of the client if none of them
+// would be exceeded. On denial the decision carries the most exhausted quota
+// and when it frees up again.
+func (qs *QuotaService) Reserve(clientID string, cost float64) (model.Decision, bool) {
+	now := time.Now()
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	states := qs.quotas[clientID]
+	for _, st := range states {
+		st.roll(now)
+		if used := st.used(); used+cost > st.quota.Limit {
+			_, _, resetAt := st.quota.Window(now, st.loc)
+			return model.Decision{
+				Allowed:       false,
+				QuotaExceeded: true,
+				Limit:         int(st.quota.Limit),
+				Remaining:     int(math.Max(0, st.quota.Limit-used)),
+				ResetAfter:    resetAt.Sub(now),
+				RetryAfter:    resetAt.Sub(now),
+			}, false
+		}
+	}
+	for _, st := range states {
+		st.add(now, cost)
+	}
+	return model.Decision{Allowed: true}, true
+}
+
+// Adjust debits (positive delta) or refunds (negative delta) the client's
+// quotas without checking them.
+func (qs *QuotaService) Adjust(clientID string, delta float64) {
+	if delta == 0 {
+		return
+	}
+	now := time.Now()
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	for _, st := range qs.quotas[clientID] {
+		st.roll(now)
+		st.add(now, delta)
+	}
+}
+
+// Usage reports the client's quotas with their usage in the current window.
+func (qs *QuotaService) Usage(clientID string) []model.QuotaUsage {
+	now := time.Now()
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	usage := make([]model.QuotaUsage, 0, len(qs.quotas[clientID]))
+	for _, st := range qs.quotas[clientID] {
+		st.roll(now)
+		_, since, resetAt := st.quota.Window(now, st.loc)
+		used := st.used()
+		usage = append(usage, model.QuotaUsage{
+			Quota:       st.quota,
+			Used:        used,
+			Remaining:   math.Max(0, st.quota.Limit-used),
+			WindowStart: since,
+			ResetsAt:    resetAt,
+		})
+	}
+	return usage
+}
+
+func (qs *QuotaService) AddQuota(quota model.Quota) (model.Quota, error) {
+	quota, err := qs.repo.AddQuota(quota)
+	if err != nil {
+		return quota, err
+	}
+	return quota, qs.Reload()
+}
+
+func (qs *QuotaService) DeleteQuota(clientID string, quotaID int64) error {
+	if err := qs.repo.DeleteQuota(clientID, quotaID); err != nil {
+		return err
+	}
+	return qs.Reload()
+}
+
+func (qs *QuotaService) DeletePlanQuota(planID string, quotaID int64) error {
+	if err := qs.repo.DeletePlanQuota(planID, quotaID); err != nil {
+		return err
+	}
+	return qs.Reload()
+}
+
+func (qs *QuotaService) ListPlanQuotas(planID string) ([]model.Quota, error) {
+	return qs.repo.ListPlanQuotas(planID)
+}
+
+// Reload re-reads the quota definitions and the stored usage, keeping the
+// pending usage of quotas that still exist. It also picks up changes of
+// plans and of the clients assigned to them.
+func (qs *QuotaService) Reload() error {
+	qs.syncMu.Lock()
+	defer qs.syncMu.Unlock()
+
+	quotas, err := qs.repo.ListQuotas()
+	if err != nil {
+		return err
+	}
+	states, err := qs.loadStates(quotas)
+	if err != nil {
+		return err
+	}
+
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	var old []*quotaState
+	for _, list := range qs.quotas {
+		old = append(old, list...)
+	}
+	keepPending(states, old)
+
+	byClient := make(map[string][]*quotaState)
+	for _, st := range states {
+		byClient[st.quota.ClientID] = append(byClient[st.quota.ClientID], st)
+	}
+	qs.quotas = byClient
+	return nil
+}
+
+// ReloadClient re-reads the quotas and stored usage of one client only,
+// e.g. after it was assigned to another plan.
+func (qs *QuotaService) ReloadClient(clientID string) error {
+	qs.syncMu.Lock()
+	defer qs.syncMu.Unlock()
+
+	quotas, err := qs.repo.ListClientQuotas(clientID)
+	if err != nil {
+		return err
+	}
+	states, err := qs.loadStates(quotas)
+	if err != nil {
+		return err
+	}
+
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	keepPending(states, qs.quotas[clientID])
+	if len(states) == 0 {
+		delete(qs.quotas, clientID)
+	} else {
+		qs.quotas[clientID] = states
+	}
+	return nil
+}
+
+// loadStates builds the states of the quotas with their stored usage in the
+// current window.
+func (qs *QuotaService) loadStates(quotas []model.Quota) ([]*quotaState, error) {
+	now := time.Now()
+	windows := make(map[repository.QuotaKey]time.Time, len(quotas))
+	states := make([]*quotaState, 0, len(quotas))
+	for _, q := range quotas {
+		loc, err := q.Location()
+		if err != nil {
+			log.Printf("Skipping quota %d of client %s: %v", q.ID, q.ClientID, err)
+			continue
+		}
+		_, since, _ := q.Window(now, loc)
+		windows[quotaKey(q)] = since
+		states = append(states, &quotaState{
+			quota:   q,
+			loc:     loc,
+			since:   since,
+			pending: make(map[time.Time]float64),
+			flushed: make(map[time.Time]float64),
+		})
+	}
+
+	totals, err := qs.repo.UsageTotals(windows)
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range states {
+		st.committed = totals[quotaKey(st.quota)]
+	}
+	return states, nil
+}
+
+// keepPending carries the unflushed usage of the old states over to the
+// reloaded ones. Flushed usage is part of the reloaded totals. Must be
+// called with qs.mu held.
+func keepPending(states []*quotaState, old []*quotaState) {
+	pending := make(map[repository.QuotaKey]map[time.Time]float64, len(old))
+	for _, st := range old {
+		pending[quotaKey(st.quota)] = st.pending
+	}
+	for _, st := range states {
+		if p, ok := pending[quotaKey(st.quota)]; ok {
+			st.pending = p
+		}
+	}
+}
+
+// flush writes the pending usage. If the write fails the usage is put back
+// to be retried on the next flush.
+func (qs *QuotaService) flush() error {
+	qs.syncMu.Lock()
+	defer qs.syncMu.Unlock()
+
+	qs.mu.Lock()
+	var increments []repository.QuotaIncrement
+	for _, states := range qs.quotas {
+		for _, st := range states {
+			for bucket, used := range st.pending {
+				if used != 0 {
+					increments = append(increments, repository.QuotaIncrement{QuotaKey: quotaKey(st.quota), Bucket: bucket, Used: used})
+					st.flushed[bucket] += used
+				}
+			}
+			st.pending = make(map[time.Time]float64)
+		}
+	}
+	prune := time.Since(qs.lastPruned) > time.Hour
+	if prune {
+		qs.lastPruned = time.Now()
+	}
+	qs.mu.Unlock()
+
+	if len(increments) > 0 {
+		if err := qs.repo.AddUsage(increments); err != nil {
+			qs.restore(increments)
+			return err
+		}
+	}
+	if prune {
+		if err := qs.repo.PruneUsage(time.Now().Add(-quotaRetention)); err != nil {
+			log.Printf("Failed to prune quota usage: %v", err)
+		}
+	}
+	return nil
+}
+
+func (qs *QuotaService) restore(increments []repository.QuotaIncrement) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	byKey := make(map[repository.QuotaKey]*quotaState)
+	for _, states := range qs.quotas {
+		for _, st := range states {
+			byKey[quotaKey(st.quota)] = st
+		}
+	}
+	for _, inc := range increments {
+		if st, ok := byKey[inc.QuotaKey]; ok {
+			st.pending[inc.Bucket] += inc.Used
+			st.flushed[inc.Bucket] -= inc.Used
+		}
+	}
+}
+
+// Close stops the background refresh and flushes the pending usage.
+func (qs *QuotaService) Close() error {
+	qs.stopOnce.Do(func() {
+		close(qs.stop)
+		<-qs.done
+	})
+	return qs.flush()
+}
+
+func quotaKey(q model.Quota) repository.QuotaKey {
+	return repository.QuotaKey{QuotaID: q.ID, ClientID: q.ClientID}
+}
+
+// roll starts a new window once a calendar period is over; the stored usage
+// of the old period no longer counts.
+func (st *quotaState) roll(now time.Time) {
+	if st.quota.Rolling {
+		return
+	}
+	if _, since, _ := st.quota.Window(now, st.loc); !since.Equal(st.since) {
+		st.since = since
+		st.committed = 0
+	}
+}
+
+func (st *quotaState) used() float64 {
+	used := st.committed
+	for _, counted := range []map[time.Time]float64{st.pending, st.flushed} {
+		for bucket, n := range counted {
+			if !bucket.Before(st.since) {
+				used += n
+			}
+		}
+	}
+	return used
+}
+
+func (st *quotaState) add(now time.Time, n float64) {
+	bucket, _, _ := st.quota.Window(now, st.loc)
+	st.pending[bucket] += n
+}
@@ -0,0 +1,331 @@
+package service
+
+import (
+	"LoadBalancer/TimeLimiter/pkg/model"
+	"LoadBalancer/TimeLimiter/pkg/repository"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeQuotaRepo keeps quotas and usage totals in memory. Usage added by
+// AddUsage is included in the totals returned afterwards.
+type fakeQuotaRepo struct {
+	mu      sync.Mutex
+	quotas  []model.Quota
+	totals  map[repository.QuotaKey]float64
+	failAdd bool
+	added   []repository.QuotaIncrement
+}
+
+func (r *fakeQuotaRepo) ListQuotas() ([]model.Quota, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]model.Quota(nil), r.quotas...), nil
+}
+
+func (r *fakeQuotaRepo) ListClientQuotas(clientID string) ([]model.Quota, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var quotas []model.Quota
+	for _, q := range r.quotas {
+		if q.ClientID == clientID {
+			quotas = append(quotas, q)
+		}
+	}
+	return quotas, nil
+}
+
+func (r *fakeQuotaRepo) ListPlanQuotas(planID string) ([]model.Quota, error) { return nil, nil }
+
+func (r *fakeQuotaRepo) AddQuota(quota model.Quota) (model.Quota, error) { return quota, nil }
+
+func (r *fakeQuotaRepo) DeleteQuota(clientID string, quotaID int64) error { return nil }
+
+func (r *fakeQuotaRepo) DeletePlanQuota(planID string, quotaID int64) error { return nil }
+
+func (r *fakeQuotaRepo) AddUsage(increments []repository.QuotaIncrement) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failAdd {
+		return errors.New("database unavailable")
+	}
+	for _, inc := range increments {
+		r.totals[inc.QuotaKey] += inc.Used
+	}
+	r.added = append(r.added, increments...)
+	return nil
+}
+
+func (r *fakeQuotaRepo) UsageTotals(windows map[repository.QuotaKey]time.Time) (map[repository.QuotaKey]float64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	totals := make(map[repository.QuotaKey]float64)
+	for key := range windows {
+		totals[key] = r.totals[key]
+	}
+	return totals, nil
+}
+
+func (r *fakeQuotaRepo) PruneUsage(before time.Time) error { return nil }
+
+func (r *fakeQuotaRepo) setFailAdd(fail bool) {
+	r.mu.Lock()
+	r.failAdd = fail
+	r.mu.Unlock()
+}
+
+func (r *fakeQuotaRepo) setTotal(key repository.QuotaKey, total float64) {
+	r.mu.Lock()
+	r.totals[key] = total
+	r.mu.Unlock()
+}
+
+func newTestQuotaService(t *testing.T, quotas []model.Quota, totals map[repository.QuotaKey]float64) (*QuotaService, *fakeQuotaRepo) {
+	t.Helper()
+	if totals == nil {
+		totals = make(map[repository.QuotaKey]float64)
+	}
+	repo := &fakeQuotaRepo{quotas: quotas, totals: totals}
+	qs, err := NewQuotaService(repo, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { qs.Close() })
+	return qs, repo
+}
+
+func dailyQuota(id int64, clientID string, limit float64) model.Quota {
+	return model.Quota{ID: id, ClientID: clientID, Period: model.QuotaDaily, Limit: limit}
+}
+
+func TestQuotaServiceReserve(t *testing.T) {
+	tests := []struct {
+		name    string
+		quotas  []model.Quota
+		totals  map[repository.QuotaKey]float64
+		costs   []float64
+		allowed []bool
+	}{
+		{
+			name:    "within the limit",
+			quotas:  []model.Quota{dailyQuota(1, "c", 10)},
+			costs:   []float64{4, 6, 0.5},
+			allowed: []bool{true, true, false},
+		},
+		{
+			name:    "stored usage counts",
+			quotas:  []model.Quota{dailyQuota(1, "c", 10)},
+			totals:  map[repository.QuotaKey]float64{{QuotaID: 1, ClientID: "c"}: 8},
+			costs:   []float64{3, 2},
+			allowed: []bool{false, true},
+		},
+		{
+			name:    "a denied request charges nothing",
+			quotas:  []model.Quota{dailyQuota(1, "c", 10)},
+			costs:   []float64{7, 5, 3},
+			allowed: []bool{true, false, true},
+		},
+		{
+			name: "every quota must allow",
+			quotas: []model.Quota{
+				dailyQuota(1, "c", 100),
+				{ID: 2, ClientID: "c", Period: model.QuotaMonthly, Limit: 5},
+			},
+			costs:   []float64{5, 1},
+			allowed: []bool{true, false},
+		},
+		{
+			name:    "quotas of other clients do not apply",
+			quotas:  []model.Quota{dailyQuota(1, "other", 1)},
+			costs:   []float64{50},
+			allowed: []bool{true},
+		},
+		{
+			name:    "plan quotas count each client separately",
+			quotas:  []model.Quota{{ID: 1, ClientID: "c", PlanID: "p", Period: model.QuotaDaily, Limit: 5}},
+			totals:  map[repository.QuotaKey]float64{{QuotaID: 1, ClientID: "d"}: 5},
+			costs:   []float64{5},
+			allowed: []bool{true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qs, _ := newTestQuotaService(t, tt.quotas, tt.totals)
+			for i, cost := range tt.costs {
+				if _, ok := qs.Reserve("c", cost); ok != tt.allowed[i] {
+					t.Fatalf("reserve %d of %v: allowed = %v, want %v", i, cost, ok, tt.allowed[i])
+				}
+			}
+		})
+	}
+}
+
+func TestQuotaServiceDenial(t *testing.T) {
+	qs, _ := newTestQuotaService(t, []model.Quota{dailyQuota(1, "c", 10)}, nil)
+	qs.Reserve("c", 7)
+
+	d, ok := qs.Reserve("c", 5)
+	if ok || d.Allowed || !d.QuotaExceeded {
+		t.Fatalf("decision = %+v, want a quota denial", d)
+	}
+	if d.Limit != 10 || d.Remaining != 3 {
+		t.Errorf("limit %d, remaining %d; want 10, 3", d.Limit, d.Remaining)
+	}
+	if d.RetryAfter <= 0 || d.RetryAfter > 24*time.Hour || d.RetryAfter != d.ResetAfter {
+		t.Errorf("RetryAfter = %v, ResetAfter = %v; want the time until midnight", d.RetryAfter, d.ResetAfter)
+	}
+}
+
+func TestQuotaServiceAdjust(t *testing.T) {
+	tests := []struct {
+		name     string
+		reserved float64
+		delta    float64
+		wantUsed float64
+	}{
+		{"refund", 6, -4, 2},
+		{"debit", 6, 3, 9},
+		{"debit beyond the limit", 6, 10, 16},
+		{"zero delta", 6, 0, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qs, _ := newTestQuotaService(t, []model.Quota{dailyQuota(1, "c", 10)}, nil)
+			qs.Reserve("c", tt.reserved)
+			qs.Adjust("c", tt.delta)
+			if got := qs.Usage("c")[0].Used; got != tt.wantUsed {
+				t.Fatalf("used = %v, want %v", got, tt.wantUsed)
+			}
+		})
+	}
+}
+
+func TestQuotaServiceRoll(t *testing.T) {
+	tests := []struct {
+		name     string
+		quota    model.Quota
+		wantUsed float64
+	}{
+		{"calendar quota starts a new window", dailyQuota(1, "c", 10), 0},
+		{"rolling quota keeps the stored usage", model.Quota{ID: 1, ClientID: "c", Period: model.QuotaDaily, Limit: 10, Rolling: true}, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			totals := map[repository.QuotaKey]float64{{QuotaID: 1, ClientID: "c"}: 8}
+			qs, _ := newTestQuotaService(t, []model.Quota{tt.quota}, totals)
+
+			// Pretend the stored usage was read during the previous day.
+			qs.mu.Lock()
+			st := qs.quotas["c"][0]
+			st.since = st.since.AddDate(0, 0, -1)
+			qs.mu.Unlock()
+
+			if got := qs.Usage("c")[0].Used; got != tt.wantUsed {
+				t.Fatalf("used = %v, want %v", got, tt.wantUsed)
+			}
+		})
+	}
+}
+
+func TestQuotaServiceFlush(t *testing.T) {
+	key := repository.QuotaKey{QuotaID: 1, ClientID: "c"}
+	qs, repo := newTestQuotaService(t, []model.Quota{dailyQuota(1, "c", 5)}, nil)
+
+	if _, ok := qs.Reserve("c", 3); !ok {
+		t.Fatal("first reserve denied")
+	}
+	if err := qs.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.added) != 1 || repo.added[0].QuotaKey != key || repo.added[0].Used != 3 {
+		t.Fatalf("added = %+v, want 3 for %v", repo.added, key)
+	}
+
+	// The flushed usage keeps counting until a reload reads it back.
+	if _, ok := qs.Reserve("c", 3); ok {
+		t.Fatal("flushed usage was not counted before the reload")
+	}
+	if err := qs.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := qs.Usage("c")[0].Used; got != 3 {
+		t.Fatalf("used after reload = %v, want 3", got)
+	}
+	if _, ok := qs.Reserve("c", 2); !ok {
+		t.Fatal("reserve within the limit denied after the reload")
+	}
+}
+
+func TestQuotaServiceFlushFailure(t *testing.T) {
+	qs, repo := newTestQuotaService(t, []model.Quota{dailyQuota(1, "c", 5)}, nil)
+	qs.Reserve("c", 3)
+
+	repo.setFailAdd(true)
+	if err := qs.flush(); err == nil {
+		t.Fatal("flush succeeded with a failing repository")
+	}
+	if got := qs.Usage("c")[0].Used; got != 3 {
+		t.Fatalf("used after a failed flush = %v, want 3", got)
+	}
+
+	repo.setFailAdd(false)
+	if err := qs.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.added) != 1 || repo.added[0].Used != 3 {
+		t.Fatalf("added = %+v, want the usage once", repo.added)
+	}
+	if got := qs.Usage("c")[0].Used; got != 3 {
+		t.Fatalf("used after the retried flush = %v, want 3", got)
+	}
+}
+
+func TestQuotaServiceReload(t *testing.T) {
+	key := repository.QuotaKey{QuotaID: 1, ClientID: "c"}
+	qs, repo := newTestQuotaService(t, []model.Quota{dailyQuota(1, "c", 10), dailyQuota(2, "d", 10)}, nil)
+	qs.Reserve("c", 2)
+	qs.Reserve("d", 1)
+
+	// Another instance stored usage meanwhile.
+	repo.setTotal(key, 5)
+
+	tests := []struct {
+		name   string
+		reload func() error
+	}{
+		{"all clients", qs.Reload},
+		{"one client", func() error { return qs.ReloadClient("c") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.reload(); err != nil {
+				t.Fatal(err)
+			}
+			if got := qs.Usage("c")[0].Used; got != 7 {
+				t.Fatalf("used = %v, want the stored 5 plus the pending 2", got)
+			}
+			if got := qs.Usage("d")[0].Used; got != 1 {
+				t.Fatalf("used by another client = %v, want 1", got)
+			}
+		})
+	}
+}
+
+func TestQuotaServiceReloadClientRemovesQuotas(t *testing.T) {
+	qs, repo := newTestQuotaService(t, []model.Quota{dailyQuota(1, "c", 1), dailyQuota(2, "d", 1)}, nil)
+
+	repo.mu.Lock()
+	repo.quotas = repo.quotas[1:]
+	repo.mu.Unlock()
+	if err := qs.ReloadClient("c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := qs.Reserve("c", 5); !ok {
+		t.Fatal("the removed quota still applies")
+	}
+	if _, ok := qs.Reserve("d", 5); ok {
+		t.Fatal("the quota of another client was dropped")
+	}
+}
@@ -1,20 +1,26 @@
 package service
 
 import (
+	"LoadBalancer/Balancer/pkg/metrics"
+	"LoadBalancer/TimeLimiter/pkg/model"
 	"LoadBalancer/TimeLimiter/pkg/repository"
 	"fmt"
+	"log"
 	"sync"
 	"time"
 )
 
 type RateLimiterService struct {
-	mu         sync.RWMutex
-	ticker     *time.Ticker
-	tickerStop chan bool
-	repo       repository.RateLimiterRepo
+	mu            sync.RWMutex
+	usage         sync.Map
+	locks         sync.Map
+	ticker        *time.Ticker
+	persistTicker *time.Ticker
+	tickerStop    chan bool
+	repo          repository.RateLimiterRepo
 }
 
-func NewRateLimiter(repo repository.RateLimiterRepo) (*RateLimiterService, error) {
+func NewRateLimiter(repo repository.RateLimiterRepo, persistInterval time.Duration) (*RateLimiterService, error) {
 	rl := &RateLimiterService{
 		repo:       repo,
 		tickerStop: make(chan bool),
@@ -24,6 +30,7 @@ func NewRateLimiter(repo repository.RateLimiterRepo) (*RateLimiterService, error
 	}
 
 	rl.ticker = time.NewTicker(time.Second)
+	rl.persistTicker = time.NewTicker(persistInterval)
 	go rl.startTokenRefill()
 	return rl, nil
 }
@@ -33,8 +40,14 @@ func (rl *RateLimiterService) startTokenRefill() {
 		select {
 		case <-rl.ticker.C:
 			rl.refillAllBuckets()
+		case <-rl.persistTicker.C:
+			if err := rl.repo.SaveState(); err != nil {
+				log.Printf("Failed to persist limiter state: %v", err)
+			}
+			rl.pruneUsage()
 		case <-rl.tickerStop:
 			rl.ticker.Stop()
+			rl.persistTicker.Stop()
 			return
 		}
 	}
@@ -44,6 +57,13 @@ func (rl *RateLimiterService) StopRefill() {
 	rl.tickerStop <- true
 }
 
+// Close stops the background refill and flushes the live token balances to
+// the database.
+func (rl *RateLimiterService) Close() error {
+	rl.StopRefill()
+	return rl.repo.SaveState()
+}
+
 func (rl *RateLimiterService) refillAllBuckets() {
 	rl.mu.RLock()
 	defer rl.mu.RUnlock()
@@ -53,17 +73,170 @@ func (rl *RateLimiterService) refillAllBuckets() {
 	}
 }
 
-func (rl *RateLimiterService) Allow(clientID string) bool {
+// Allow charges the cost to the client's bucket, to its organization's
+// bucket and to the buckets of every route policy matching the request. The
+// request passes only if all of them have enough tokens.
+//
+// When more than one bucket is involved, the client's requests go through
+// them one at a time and a local bucket that already lacks the tokens is
+// tried first, so a denied request normally takes nothing and concurrent
+// requests of the client never see a partial charge. This is not atomic
+// against other clients of the same organization or other instances: if a
+// bucket still denies after others were taken, those are refunded. The
+// client's own bucket, which may be shared through the database, is taken
+// last to keep refunds off the database in the common case.
+func (rl *RateLimiterService) Allow(clientID string, override *model.LimitOverride, method string, path string, cost float64) (model.Decision, bool) {
+	limiters, ok := rl.matchLimiters(clientID, override, method, path)
+	if !ok {
+		return model.Decision{}, false
+	}
+
+	var decision model.Decision
+	if len(limiters) == 1 {
+		decision = limiters[0].Take(cost)
+	} else {
+		mu := rl.clientLock(clientID)
+		mu.Lock()
+		decision = takeAll(limiters, cost)
+		mu.Unlock()
+	}
+	rl.recordUsage(clientID, decision.Allowed)
+	if decision.Allowed {
+		metrics.RecordClientDecision(clientID, "allow")
+	} else {
+		metrics.RecordClientDecision(clientID, "deny")
+	}
+	return decision, true
+}
+
+// takeAll takes the cost from every limiter, refunding the ones already
+// taken on the first denial. The decision reports the most constrained one.
+func takeAll(limiters []model.Limiter, cost float64) model.Decision {
+	for i, limiter := range limiters {
+		if _, shared := limiter.(model.SharedLimiter); !shared && limiter.Available() < cost {
+			limiters[0], limiters[i] = limiters[i], limiters[0]
+			break
+		}
+	}
+
+	var decision model.Decision
+	for i, limiter := range limiters {
+		d := limiter.Take(cost)
+		if !d.Allowed {
+			for _, taken := range limiters[:i] {
+				taken.Adjust(-cost)
+			}
+			return d
+		}
+		if i == 0 || d.Remaining < decision.Remaining {
+			decision = d
+		}
+	}
+	return decision
+}
+
+func (rl *RateLimiterService) clientLock(clientID string) *sync.Mutex {
+	mu, ok := rl.locks.Load(clientID)
+	if !ok {
+		mu, _ = rl.locks.LoadOrStore(clientID, &sync.Mutex{})
+	}
+	return mu.(*sync.Mutex)
+}
+
+// Adjust debits (positive delta) or refunds (negative delta) the buckets
+// that the request was charged to. known is false if the limiter does not
+// know the client.
+func (rl *RateLimiterService) Adjust(clientID string, override *model.LimitOverride, method string, path string, delta float64) (known bool) {
+	limiters, known := rl.matchLimiters(clientID, override, method, path)
+	if delta == 0 {
+		return known
+	}
+	for _, limiter := range limiters {
+		limiter.Adjust(delta)
+	}
+	return known
+}
+
+// matchLimiters returns the buckets of the route policies matching the
+// request and of the client's organization followed by the client's own
+// bucket, or the override bucket in its place.
+func (rl *RateLimiterService) matchLimiters(clientID string, override *model.LimitOverride, method string, path string) ([]model.Limiter, bool) {
 	rl.mu.RLock()
 	bucket, ok := rl.repo.GetBuckets()[clientID]
+	policies := rl.repo.GetPolicies()[clientID]
+	org := rl.repo.GetOrgBucket(clientID)
 	rl.mu.RUnlock()
 
 	if !ok {
-		return false
+		return nil, false
 	}
+	limiters := make([]model.Limiter, 0, len(policies)+2)
+	for _, pl := range policies {
+		if pl.Policy.Match(method, path) {
+			limiters = append(limiters, pl.Limiter)
+		}
+	}
+	if org != nil {
+		limiters = append(limiters, org)
+	}
+	if override != nil {
+		bucket = rl.repo.GetOverrideBucket(clientID, override.Config(clientID))
+	}
+	return append(limiters, bucket), true
+}
+
+func (rl *RateLimiterService) recordUsage(clientID string, allowed bool) {
+	stats, ok := rl.usage.Load(clientID)
+	if !ok {
+		stats, _ = rl.usage.LoadOrStore(clientID, &usageStats{windowStart: time.Now().Truncate(usageWindow)})
+	}
+	stats.(*usageStats).record(allowed)
+}
+
+// pruneUsage forgets the statistics and locks of deleted clients.
+func (rl *RateLimiterService) pruneUsage() {
+	buckets := rl.repo.GetBuckets()
+	for _, m := range []*sync.Map{&rl.usage, &rl.locks} {
+		m.Range(func(key, _ any) bool {
+			if _, ok := buckets[key.(string)]; !ok {
+				m.Delete(key)
+			}
+			return true
+		})
+	}
+}
+
+// Usage reports the live state of a client's limiter in this instance, or
+// nil if the client has no limiter loaded.
+func (rl *RateLimiterService) Usage(clientID string) *model.ClientUsage {
+	rl.mu.RLock()
+	bucket, ok := rl.repo.GetBuckets()[clientID]
+	rl.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	usage := &model.ClientUsage{
+		Available:  bucket.Available(),
+		LastRefill: bucket.State().LastRefill,
+	}
+	if override := rl.repo.OverrideBucket(clientID); override != nil {
+		available := override.Available()
+		usage.OverrideAvailable = &available
+	}
+	if stats, ok := rl.usage.Load(clientID); ok {
+		usage.AllowedLastMinute, usage.DeniedLastMinute = stats.(*usageStats).lastMinute()
+	}
+	return usage
+}
+
+func (rl *RateLimiterService) TokenLevels() map[string]float64 {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
 
-	if bucket.Take(1) {
-		return true
+	levels := make(map[string]float64)
+	for clientID, bucket := range rl.repo.GetBuckets() {
+		levels[clientID] = bucket.Available()
 	}
-	return false
+	return levels
 }
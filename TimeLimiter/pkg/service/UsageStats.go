@@ -0,0 +1,58 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+const usageWindow = time.Minute
+
+// usageStats counts a client's allowed and denied requests in two fixed
+// one-minute windows, the previous one weighted by its overlap with the last
+// minute, like model.SlidingWindowCounter.
+type usageStats struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	prevAllowed float64
+	prevDenied  float64
+	curAllowed  float64
+	curDenied   float64
+}
+
+func (u *usageStats) record(allowed bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.advance(time.Now())
+	if allowed {
+		u.curAllowed++
+	} else {
+		u.curDenied++
+	}
+}
+
+// lastMinute returns the estimated allowed and denied counts over the last
+// minute.
+func (u *usageStats) lastMinute() (float64, float64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	u.advance(now)
+	overlap := 1 - float64(now.Sub(u.windowStart))/float64(usageWindow)
+	return u.prevAllowed*overlap + u.curAllowed, u.prevDenied*overlap + u.curDenied
+}
+
+func (u *usageStats) advance(now time.Time) {
+	elapsed := now.Sub(u.windowStart)
+	if elapsed < usageWindow {
+		return
+	}
+	if elapsed < 2*usageWindow {
+		u.prevAllowed, u.prevDenied = u.curAllowed, u.curDenied
+	} else {
+		u.prevAllowed, u.prevDenied = 0, 0
+	}
+	u.curAllowed, u.curDenied = 0, 0
+	u.windowStart = now.Truncate(usageWindow)
+}
@@ -1,32 +1,130 @@
 package service
 
 import (
+	"LoadBalancer/Balancer/pkg/metrics"
 	"LoadBalancer/TimeLimiter/pkg/model"
 	"LoadBalancer/TimeLimiter/pkg/repository"
+	"log"
+	"net/http"
 )
 
 type Userservice interface {
-	Allow(clientID string) bool
+	Allow(identity Identity, r *http.Request, cost float64) (model.Decision, bool)
+	RequestCost(r *http.Request) float64
+	SettleCost(identity Identity, r *http.Request, charged float64, reported http.Header)
 }
 
 type UserserviceImpl struct {
 	RLservice *RateLimiterService
-	repo      repository.UserRepo
+	Costs     *CostService
+	Quotas    *QuotaService
+	// Anonymous limits the clients the limiter does not know by their IP
+	// address; when it is nil they are rejected with UnknownStatus.
+	Anonymous     *AnonymousLimiter
+	UnknownStatus int
+	repo          repository.UserRepo
 }
 
-func NewUserserviceImpl(RLservice *RateLimiterService, repo repository.UserRepo) *UserserviceImpl {
+func NewUserserviceImpl(RLservice *RateLimiterService, costs *CostService, quotas *QuotaService, repo repository.UserRepo) *UserserviceImpl {
 	return &UserserviceImpl{
-		RLservice: RLservice,
-		repo:      repo,
+		RLservice:     RLservice,
+		Costs:         costs,
+		Quotas:        quotas,
+		UnknownStatus: http.StatusForbidden,
+		repo:          repo,
 	}
 }
 
-func (us *UserserviceImpl) Allow(clientID string) bool {
-	return us.RLservice.Allow(clientID)
+// SetUnknownClientPolicy configures how requests of unknown clients are
+// treated: by the anonymous tier if it is not nil, otherwise they are
+// rejected with the status.
+func (us *UserserviceImpl) SetUnknownClientPolicy(status int, anonymous *AnonymousLimiter) {
+	us.UnknownStatus = status
+	us.Anonymous = anonymous
+}
+
+// Allow checks the client's quotas first and then its rate limits; the
+// quota charge is refunded if the rate limiter denies the request. Clients
+// the limiter does not know, including requests without credentials (an
+// empty client ID), go to the anonymous tier if there is one, otherwise
+// known is false.
+func (us *UserserviceImpl) Allow(identity Identity, r *http.Request, cost float64) (model.Decision, bool) {
+	clientID := identity.ClientID
+	if clientID == "" {
+		return us.allowUnknown(r, cost)
+	}
+	if decision, ok := us.Quotas.Reserve(clientID, cost); !ok {
+		metrics.RecordClientDecision(clientID, "quota_exceeded")
+		return decision, true
+	}
+	decision, known := us.RLservice.Allow(clientID, identity.Override, r.Method, r.URL.Path, cost)
+	if !decision.Allowed {
+		us.Quotas.Adjust(clientID, -cost)
+	}
+	if !known {
+		return us.allowUnknown(r, cost)
+	}
+	return decision, true
+}
+
+func (us *UserserviceImpl) allowUnknown(r *http.Request, cost float64) (model.Decision, bool) {
+	if us.Anonymous == nil {
+		metrics.RateLimitDecisions.Inc("unknown", "deny")
+		return model.Decision{}, false
+	}
+	decision := us.Anonymous.Allow(r, cost)
+	if decision.Allowed {
+		metrics.RateLimitDecisions.Inc("anonymous", "allow")
+	} else {
+		metrics.RateLimitDecisions.Inc("anonymous", "deny")
+	}
+	return decision, true
+}
+
+func (us *UserserviceImpl) RequestCost(r *http.Request) float64 {
+	return us.Costs.RequestCost(r)
+}
+
+// SettleCost debits or refunds the difference between what was charged up
+// front and the cost the backend reported in its response. Requests that
+// Allow sent to the anonymous tier are settled against it as well.
+func (us *UserserviceImpl) SettleCost(identity Identity, r *http.Request, charged float64, reported http.Header) {
+	actual, ok := us.Costs.ReportedCost(reported)
+	if !ok {
+		return
+	}
+	delta := actual - charged
+	if identity.ClientID != "" && us.RLservice.Adjust(identity.ClientID, identity.Override, r.Method, r.URL.Path, delta) {
+		us.Quotas.Adjust(identity.ClientID, delta)
+		return
+	}
+	if us.Anonymous != nil {
+		us.Anonymous.Adjust(r, delta)
+	}
+}
+
+func (us *UserserviceImpl) ListPolicies(clientID string) ([]model.RoutePolicy, error) {
+	return us.repo.ListPolicies(clientID)
+}
+
+func (us *UserserviceImpl) AddPolicy(policy model.RoutePolicy) (model.RoutePolicy, error) {
+	return us.repo.AddPolicy(policy)
+}
+
+func (us *UserserviceImpl) UpdatePolicy(policy model.RoutePolicy) error {
+	return us.repo.UpdatePolicy(policy)
+}
+
+func (us *UserserviceImpl) DeletePolicy(clientID string, policyID int64) error {
+	return us.repo.DeletePolicy(clientID, policyID)
 }
 
 func (us *UserserviceImpl) AddClient(config model.ClientConfig) error {
-	return us.repo.AddClient(config)
+	if err := us.repo.AddClient(config); err != nil {
+		return err
+	}
+	us.reloadQuotas(config)
+	return nil
 }
 
 func (us *UserserviceImpl) DeleteClient(clientID string) error {
@@ -34,9 +132,160 @@ func (us *UserserviceImpl) DeleteClient(clientID string) error {
 }
 
 func (us *UserserviceImpl) UpdateClient(config model.ClientConfig) error {
-	return us.repo.UpdateClient(config)
+	if err := us.repo.UpdateClient(config); err != nil {
+		return err
+	}
+	us.reloadQuotas(config)
+	return nil
+}
+
+// reloadQuotas applies the quotas of the plan a client was assigned to
+// right away instead of on the next periodic refresh.
+func (us *UserserviceImpl) reloadQuotas(config model.ClientConfig) {
+	if err := us.Quotas.ReloadClient(config.ClientID); err != nil {
+		log.Printf("Failed to reload quotas after changing client %s: %v", config.ClientID, err)
+	}
 }
 
 func (us *UserserviceImpl) GetClients() error {
 	return us.repo.GetClients()
 }
+
+func (us *UserserviceImpl) ListClients(filter model.ClientFilter) (model.ClientPage, error) {
+	configs, total, err := us.repo.ListClients(filter)
+	if err != nil {
+		return model.ClientPage{}, err
+	}
+
+	page := model.ClientPage{
+		Clients: make([]model.ClientStatus, 0, len(configs)),
+		Total:   total,
+		Limit:   filter.Limit,
+		Offset:  filter.Offset,
+	}
+	for _, config := range configs {
+		page.Clients = append(page.Clients, us.clientStatus(config))
+	}
+	return page, nil
+}
+
+func (us *UserserviceImpl) GetClient(clientID string) (model.ClientStatus, bool, error) {
+	config, found, err := us.repo.GetClient(clientID)
+	if err != nil || !found {
+		return model.ClientStatus{}, found, err
+	}
+	return us.clientStatus(config), true, nil
+}
+
+func (us *UserserviceImpl) clientStatus(config model.ClientConfig) model.ClientStatus {
+	status := model.ClientStatus{
+		ClientConfig: config,
+		Usage:        us.RLservice.Usage(config.ClientID),
+	}
+	if status.Usage != nil {
+		status.CurrentTokens = status.Usage.Available
+	}
+	if config.PlanID != "" {
+		if effective, err := us.repo.ResolveClient(config); err == nil {
+			effective.CurrentTokens = status.CurrentTokens
+			status.Effective = &effective
+		}
+	}
+	return status
+}
+
+func (us *UserserviceImpl) ListOrgs() ([]model.Organization, error) {
+	return us.repo.ListOrgs()
+}
+
+// GetOrg returns the organization with the live balance of its bucket in
+// this instance.
+func (us *UserserviceImpl) GetOrg(orgID string) (model.Organization, bool, error) {
+	org, found, err := us.repo.GetOrg(orgID)
+	if err != nil || !found {
+		return org, found, err
+	}
+	if bucket, ok := us.repo.GetOrgBuckets()[orgID]; ok {
+		org.CurrentTokens = bucket.Available()
+	}
+	return org, true, nil
+}
+
+func (us *UserserviceImpl) AddOrg(org model.Organization) error {
+	return us.repo.AddOrg(org)
+}
+
+func (us *UserserviceImpl) UpdateOrg(org model.Organization) error {
+	return us.repo.UpdateOrg(org)
+}
+
+func (us *UserserviceImpl) DeleteOrg(orgID string) error {
+	return us.repo.DeleteOrg(orgID)
+}
+
+func (us *UserserviceImpl) ListPlans() ([]model.Plan, error) {
+	return us.repo.ListPlans()
+}
+
+// GetPlan returns the plan together with its quotas and route policies.
+func (us *UserserviceImpl) GetPlan(planID string) (model.Plan, bool, error) {
+	plan, found, err := us.repo.GetPlan(planID)
+	if err != nil || !found {
+		return plan, found, err
+	}
+	if plan.Quotas, err = us.Quotas.ListPlanQuotas(planID); err != nil {
+		return plan, false, err
+	}
+	if plan.Policies, err = us.repo.ListPlanPolicies(planID); err != nil {
+		return plan, false, err
+	}
+	return plan, true, nil
+}
+
+func (us *UserserviceImpl) AddPlan(plan model.Plan) error {
+	return us.repo.AddPlan(plan)
+}
+
+func (us *UserserviceImpl) UpdatePlan(plan model.Plan) error {
+	return us.repo.UpdatePlan(plan)
+}
+
+func (us *UserserviceImpl) DeletePlan(planID string) error {
+	if err := us.repo.DeletePlan(planID); err != nil {
+		return err
+	}
+	// The plan's quotas were deleted with it.
+	return us.Quotas.Reload()
+}
+
+func (us *UserserviceImpl) ListPlanPolicies(planID string) ([]model.RoutePolicy, error) {
+	return us.repo.ListPlanPolicies(planID)
+}
+
+func (us *UserserviceImpl) AddPlanPolicy(policy model.RoutePolicy) (model.RoutePolicy, error) {
+	return us.repo.AddPlanPolicy(policy)
+}
+
+func (us *UserserviceImpl) UpdatePlanPolicy(policy model.RoutePolicy) error {
+	return us.repo.UpdatePlanPolicy(policy)
+}
+
+func (us *UserserviceImpl) DeletePlanPolicy(planID string, policyID int64) error {
+	return us.repo.DeletePlanPolicy(planID, policyID)
+}
+
+func (us *UserserviceImpl) DeletePlanQuota(planID string, quotaID int64) error {
+	return us.Quotas.DeletePlanQuota(planID, quotaID)
+}
+
+func (us *UserserviceImpl) AddQuota(quota model.Quota) (model.Quota, error) {
+	return us.Quotas.AddQuota(quota)
+}
+
+func (us *UserserviceImpl) DeleteQuota(clientID string, quotaID int64) error {
+	return us.Quotas.DeleteQuota(clientID, quotaID)
+}
+
+func (us *UserserviceImpl) QuotaUsage(clientID string) []model.QuotaUsage {
+	return us.Quotas.Usage(clientID)
+}
@@ -10,16 +10,19 @@ import (
 
 	lbCon "LoadBalancer/Balancer/pkg/controller"
 	"LoadBalancer/Balancer/pkg/health"
+	"LoadBalancer/Balancer/pkg/metrics"
 	lbServ "LoadBalancer/Balancer/pkg/service"
 	"LoadBalancer/Balancer/pkg/utils"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/gorilla/mux"
 )
@@ -27,55 +30,76 @@ import (
 func main() {
 	var serverList string
 	var port int
-	flag.StringVar(&serverList, "backends", "", "Load balanced backends, use commas to separate")
+	var strategy string
+	var hashKey string
+	var virtualNodes int
+	var healthStatus string
+	healthCfg := utils.DefaultHealthCheckConfig()
+	var outlierCfg lbServ.OutlierConfig
+	var shutdownTimeout time.Duration
+	flag.StringVar(&serverList, "backends", "", "Load balanced backends, use commas to separate, e.g. http://b1:80;weight=5")
 	flag.IntVar(&port, "port", 3030, "Port to serve")
+	flag.StringVar(&strategy, "strategy", lbServ.RoundRobin, "Balancing strategy: round-robin, weighted-round-robin, least-connections, consistent-hash or p2c-ewma")
+	flag.StringVar(&hashKey, "hash-key", "query:client_id", "Consistent hash key: query:<name>, header:<name>, cookie:<name> or ip")
+	flag.IntVar(&virtualNodes, "virtual-nodes", 100, "Virtual nodes per backend weight unit on the consistent hash ring")
+	flag.DurationVar(&healthCfg.Interval, "health-interval", healthCfg.Interval, "Interval between backend health checks")
+	flag.StringVar(&healthCfg.Type, "health-type", healthCfg.Type, "Health probe type: tcp or http")
+	flag.StringVar(&healthCfg.Method, "health-method", healthCfg.Method, "HTTP health probe method")
+	flag.StringVar(&healthCfg.Path, "health-path", healthCfg.Path, "HTTP health probe path")
+	flag.StringVar(&healthStatus, "health-status", "200-399", "Expected HTTP health status, e.g. 200, 200-299 or 2xx")
+	flag.StringVar(&healthCfg.BodyContains, "health-body", "", "Substring the HTTP health response body must contain")
+	flag.DurationVar(&healthCfg.Timeout, "health-timeout", healthCfg.Timeout, "Health probe timeout")
+	flag.IntVar(&healthCfg.Rise, "health-rise", healthCfg.Rise, "Consecutive successful probes before a backend is marked up")
+	flag.IntVar(&healthCfg.Fall, "health-fall", healthCfg.Fall, "Consecutive failed probes before a backend is marked down")
+	flag.IntVar(&outlierCfg.Consecutive5xx, "outlier-consecutive-5xx", 0, "Eject a backend after this many consecutive 5xx responses (0 disables)")
+	flag.Float64Var(&outlierCfg.ErrorRate, "outlier-error-rate", 0, "Eject a backend when its 5xx ratio within the window reaches this value (0 disables)")
+	flag.DurationVar(&outlierCfg.Window, "outlier-window", 10*time.Second, "Window for the outlier error rate")
+	flag.IntVar(&outlierCfg.MinRequests, "outlier-min-requests", 20, "Minimum requests in the window before the error rate is evaluated")
+	flag.DurationVar(&outlierCfg.BaseEjection, "outlier-base-ejection", 30*time.Second, "First ejection duration, doubled on every repeated ejection")
+	flag.DurationVar(&outlierCfg.MaxEjection, "outlier-max-ejection", 5*time.Minute, "Maximum ejection duration")
+	flag.IntVar(&outlierCfg.MaxEjectionPercent, "outlier-max-ejection-percent", 50, "Maximum percentage of backends ejected at once")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "Time allowed for in-flight requests to finish on shutdown")
 	flag.Parse()
 
 	if len(serverList) == 0 {
 		log.Fatal("Please provide one or more backends to load balance")
 	}
 
+	statusMin, statusMax, err := utils.ParseStatusRange(healthStatus)
+	if err != nil {
+		log.Fatalf("Invalid -health-status: %v", err)
+	}
+	healthCfg.StatusMin, healthCfg.StatusMax = statusMin, statusMax
+	if healthCfg.Type != utils.ProbeTCP && healthCfg.Type != utils.ProbeHTTP {
+		log.Fatalf("Invalid -health-type %q", healthCfg.Type)
+	}
+	if healthCfg.Interval <= 0 || healthCfg.Rise < 1 || healthCfg.Fall < 1 {
+		log.Fatal("Health check interval, rise and fall must be positive")
+	}
+
 	Backends := strings.Split(serverList, ",")
-	serverPool := &lbServ.ServerPool{}
+	serverPool, err := lbServ.NewLoadBalancerService(lbServ.PoolConfig{
+		Strategy:     strategy,
+		HashKey:      hashKey,
+		VirtualNodes: virtualNodes,
+		Outlier:      outlierCfg,
+	})
+	if err != nil {
+		log.Fatalf("Failed to create load balancer: %v", err)
+	}
 	con := lbCon.NewLoadBlancerController(serverPool)
 	for _, backend := range Backends {
-		backendUrl, err := url.Parse(backend)
+		backendUrl, weight, err := utils.ParseBackend(backend)
 		if err != nil {
 			log.Fatalf("Failed to parse backend URL %s: %v", backend, err)
 		}
 
-		proxy := httputil.NewSingleHostReverseProxy(backendUrl)
-		proxy.ErrorHandler = func(writer http.ResponseWriter, request *http.Request, e error) {
-			log.Printf("[%s] %s\n", backendUrl.Host, e.Error())
-			retries := utils.GetRetryFromContext(request)
-			if retries < 3 {
-				select {
-				case <-time.After(10 * time.Millisecond):
-					ctx := context.WithValue(request.Context(), utils.Retry, retries+1)
-					proxy.ServeHTTP(writer, request.WithContext(ctx))
-				}
-				return
-			}
-
-			serverPool.MarkBackendStatus(backendUrl, false)
-
-			attempts := utils.GetAttemptsFromContext(request)
-			log.Printf("%s(%s) Attempting retry %d\n", request.RemoteAddr, request.URL.Path, attempts)
-			ctx := context.WithValue(request.Context(), utils.Attempts, attempts+1)
-			con.BalanceRequest(writer, request.WithContext(ctx))
+		if err := con.AddNewBackend(con.NewBackend(backendUrl, weight)); err != nil {
+			log.Printf("Skipping backend %s: %v", backend, err)
 		}
-
-		con.AddNewBackend(&lbServ.Backend{
-			URL:          backendUrl,
-			Alive:        true,
-			ReverseProxy: proxy,
-		})
 	}
 
-	lbService := serverPool
-	lbController := lbCon.NewLoadBlancerController(lbService)
-
-	healthChecker := health.NewLHealthChecker(lbService)
+	healthChecker := health.NewLHealthChecker(serverPool, healthCfg)
 	go healthChecker.HealthCheck()
 
 	cfg, err := config.LoadConfig()
@@ -87,7 +111,6 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to connect to DB: %v", err)
 	}
-	defer db.Close()
 
 	err = database.Migrate(db)
 	if err != nil {
@@ -95,24 +118,142 @@ func main() {
 	}
 
 	userRepo := repository.NewUserRepoImpl(db)
+	leases, err := service.NewLeaseManager(service.DistributedConfig{
+		Mode:         cfg.RateLimitMode,
+		LeaseSize:    cfg.LeaseSize,
+		SyncInterval: cfg.LeaseSyncInterval,
+	}, repository.NewTokenStateRepoImpl(db))
+	if err != nil {
+		log.Fatalf("Failed to configure rate limit mode: %v", err)
+	}
+	userRepo.SetLimiterFactory(leases.NewLimiter)
 	tlRepo := repository.NewRlRepoImpl(userRepo)
 
-	rl, err := service.NewRateLimiter(tlRepo)
+	rl, err := service.NewRateLimiter(tlRepo, cfg.PersistInterval)
 	if err != nil {
 		log.Fatalf("Failed to make RateLimiter: %v", err)
 	}
-	defer rl.StopRefill()
-	userService := service.NewUserserviceImpl(rl, userRepo)
+	clientSync, err := service.NewClientSyncService(cfg.DatabaseURL, userRepo, cfg.ClientSyncInterval)
+	if err != nil {
+		log.Fatalf("Failed to listen for client changes: %v", err)
+	}
+
+	quotaService, err := service.NewQuotaService(repository.NewQuotaRepoImpl(db), cfg.QuotaFlushInterval)
+	if err != nil {
+		log.Fatalf("Failed to load quotas: %v", err)
+	}
+
+	apiKeys := service.NewApiKeyService(repository.NewApiKeyRepoImpl(db), cfg.ApiKeyCacheTTL)
+	var jwtAuth *service.JWTAuthenticator
+	if cfg.AuthMode == service.JWTAuth {
+		jwtAuth, err = service.NewJWTAuthenticator(service.JWTConfig{
+			JWKSFile:        cfg.JWTJWKSFile,
+			JWKSURL:         cfg.JWTJWKSURL,
+			Audience:        cfg.JWTAudience,
+			Issuer:          cfg.JWTIssuer,
+			ClientIDClaim:   cfg.JWTClientIDClaim,
+			CapacityClaim:   cfg.JWTCapacityClaim,
+			RateClaim:       cfg.JWTRateClaim,
+			Leeway:          cfg.JWTLeeway,
+			RefreshInterval: cfg.JWTJWKSRefresh,
+		})
+		if err != nil {
+			log.Fatalf("Failed to configure JWT authentication: %v", err)
+		}
+	}
+	auth, err := service.NewAuthenticator(cfg.AuthMode, apiKeys, jwtAuth)
+	if err != nil {
+		log.Fatalf("Failed to configure authentication: %v", err)
+	}
+
+	costService := service.NewCostService(cfg.CostRules, cfg.CostResponseHeader)
+	userService := service.NewUserserviceImpl(rl, costService, quotaService, userRepo)
+	var anonymous *service.AnonymousLimiter
+	if cfg.UnknownClientMode == service.AnonymousUnknown {
+		anonymous = service.NewAnonymousLimiter(service.AnonymousConfig{
+			Capacity:       cfg.AnonCapacity,
+			RatePerSec:     cfg.AnonRatePerSec,
+			TrustedProxies: cfg.TrustedProxies,
+			IdleTimeout:    cfg.AnonIdleTimeout,
+			MaxBuckets:     cfg.AnonMaxBuckets,
+		})
+	}
+	userService.SetUnknownClientPolicy(cfg.UnknownClientCode, anonymous)
+
+	metrics.NewGaugeFunc("lb_backend_up", "Whether the backend currently receives traffic (1) or not (0).", "backend", func() map[string]float64 {
+		up := make(map[string]float64)
+		for _, b := range serverPool.GetBackends() {
+			up[b.URL.Host] = 0
+			if b.IsAvailable() {
+				up[b.URL.Host] = 1
+			}
+		}
+		return up
+	})
+	if cfg.MetricsPerClient {
+		metrics.EnableClientDecisions()
+		metrics.NewGaugeFunc("ratelimit_tokens", "Tokens currently available in the client's bucket.", "client_id", rl.TokenLevels)
+	}
+
+	// The admin API gets its own listener so that clients of the proxy
+	// cannot reach it; ADMIN_TOKEN additionally requires a bearer token.
 	router := mux.NewRouter()
-	handler := controller.NewUserControllerImpl(userService, lbController)
+	adminRouter := mux.NewRouter()
+	adminRouter.Use(controller.RequireAdminToken(cfg.AdminToken))
+	handler := controller.NewUserControllerImpl(userService, auth, con)
+	keyHandler := controller.NewApiKeyControllerImpl(apiKeys)
+	policyHandler := controller.NewPolicyControllerImpl(userService)
+	orgHandler := controller.NewOrganizationControllerImpl(userService)
+	quotaHandler := controller.NewQuotaControllerImpl(userService)
+	planHandler := controller.NewPlanControllerImpl(userService)
+	adminHandler := lbCon.NewBackendAdminController(serverPool, con)
 
-	router.HandleFunc("/clients", handler.AddClient).Methods("POST")
-	router.HandleFunc("/clients/{client_id}", handler.DeleteClient).Methods("DELETE")
-	router.HandleFunc("/clients", handler.UpdateClient).Methods("PUT")
-	router.HandleFunc("/", handler.CheckRateLimit).Methods("GET")
+	adminRouter.HandleFunc("/clients", handler.ListClients).Methods("GET")
+	adminRouter.HandleFunc("/clients/{client_id}", handler.GetClient).Methods("GET")
+	adminRouter.HandleFunc("/clients", handler.AddClient).Methods("POST")
+	adminRouter.HandleFunc("/clients/{client_id}", handler.DeleteClient).Methods("DELETE")
+	adminRouter.HandleFunc("/clients", handler.UpdateClient).Methods("PUT")
+	adminRouter.HandleFunc("/clients/{client_id}/policies", policyHandler.ListPolicies).Methods("GET")
+	adminRouter.HandleFunc("/clients/{client_id}/policies", policyHandler.AddPolicy).Methods("POST")
+	adminRouter.HandleFunc("/clients/{client_id}/policies/{policy_id}", policyHandler.UpdatePolicy).Methods("PUT")
+	adminRouter.HandleFunc("/clients/{client_id}/policies/{policy_id}", policyHandler.DeletePolicy).Methods("DELETE")
+	adminRouter.HandleFunc("/clients/{client_id}/quotas", quotaHandler.AddQuota).Methods("POST")
+	adminRouter.HandleFunc("/clients/{client_id}/quotas/{quota_id}", quotaHandler.DeleteQuota).Methods("DELETE")
+	adminRouter.HandleFunc("/clients/{client_id}/usage", quotaHandler.GetUsage).Methods("GET")
+	adminRouter.HandleFunc("/clients/{client_id}/keys", keyHandler.ListKeys).Methods("GET")
+	adminRouter.HandleFunc("/clients/{client_id}/keys", keyHandler.IssueKey).Methods("POST")
+	adminRouter.HandleFunc("/clients/{client_id}/keys/rotate", keyHandler.RotateKey).Methods("POST")
+	adminRouter.HandleFunc("/clients/{client_id}/keys/{key_id}", keyHandler.RevokeKey).Methods("DELETE")
+	adminRouter.HandleFunc("/orgs", orgHandler.ListOrgs).Methods("GET")
+	adminRouter.HandleFunc("/orgs/{org_id}", orgHandler.GetOrg).Methods("GET")
+	adminRouter.HandleFunc("/orgs", orgHandler.AddOrg).Methods("POST")
+	adminRouter.HandleFunc("/orgs", orgHandler.UpdateOrg).Methods("PUT")
+	adminRouter.HandleFunc("/orgs/{org_id}", orgHandler.DeleteOrg).Methods("DELETE")
+	adminRouter.HandleFunc("/plans", planHandler.ListPlans).Methods("GET")
+	adminRouter.HandleFunc("/plans/{plan_id}", planHandler.GetPlan).Methods("GET")
+	adminRouter.HandleFunc("/plans", planHandler.AddPlan).Methods("POST")
+	adminRouter.HandleFunc("/plans", planHandler.UpdatePlan).Methods("PUT")
+	adminRouter.HandleFunc("/plans/{plan_id}", planHandler.DeletePlan).Methods("DELETE")
+	adminRouter.HandleFunc("/plans/{plan_id}/policies", planHandler.ListPlanPolicies).Methods("GET")
+	adminRouter.HandleFunc("/plans/{plan_id}/policies", planHandler.AddPlanPolicy).Methods("POST")
+	adminRouter.HandleFunc("/plans/{plan_id}/policies/{policy_id}", planHandler.UpdatePlanPolicy).Methods("PUT")
+	adminRouter.HandleFunc("/plans/{plan_id}/policies/{policy_id}", planHandler.DeletePlanPolicy).Methods("DELETE")
+	adminRouter.HandleFunc("/plans/{plan_id}/quotas", planHandler.AddPlanQuota).Methods("POST")
+	adminRouter.HandleFunc("/plans/{plan_id}/quotas/{quota_id}", planHandler.DeletePlanQuota).Methods("DELETE")
+	adminRouter.HandleFunc("/admin/backends", adminHandler.ListBackends).Methods("GET")
+	adminRouter.HandleFunc("/admin/backends", adminHandler.AddBackend).Methods("POST")
+	adminRouter.HandleFunc("/admin/backends/{host}", adminHandler.GetBackend).Methods("GET")
+	adminRouter.HandleFunc("/admin/backends/{host}", adminHandler.UpdateBackend).Methods("PUT")
+	adminRouter.HandleFunc("/admin/backends/{host}/drain", adminHandler.DrainBackend).Methods("POST")
+	adminRouter.HandleFunc("/admin/backends/{host}", adminHandler.RemoveBackend).Methods("DELETE")
+	adminRouter.Handle("/metrics", metrics.Default.Handler()).Methods("GET")
+	// Every method and path on the public listener is rate limited and
+	// proxied; nothing there is served by this process itself.
+	router.PathPrefix("/").HandlerFunc(handler.CheckRateLimit)
 
 	serverAddr := fmt.Sprintf(":%d", cfg.Port)
 	log.Printf("Starting server on %s\n", serverAddr)
+	log.Printf("Starting admin API on %s\n", cfg.AdminAddr)
 
 	server := &http.Server{
 		Addr:         serverAddr,
@@ -121,9 +262,59 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  15 * time.Second,
 	}
+	adminServer := &http.Server{
+		Addr:         cfg.AdminAddr,
+		Handler:      adminRouter,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  15 * time.Second,
+	}
 
-	if err := server.ListenAndServe(); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 2)
+	for _, srv := range []*http.Server{server, adminServer} {
+		go func(srv *http.Server) {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serverErr <- fmt.Errorf("%s: %w", srv.Addr, err)
+			}
+		}(srv)
+	}
+
+	select {
+	case err := <-serverErr:
 		log.Fatalf("Server failed to start: %v", err)
+	case <-ctx.Done():
+		log.Println("Shutdown signal received, draining in-flight requests...")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown did not complete: %v", err)
+	}
+	if err := adminServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Admin API shutdown did not complete: %v", err)
+	}
+
+	healthChecker.Stop()
+	clientSync.Stop()
+	if jwtAuth != nil {
+		jwtAuth.Stop()
+	}
+	if anonymous != nil {
+		anonymous.Stop()
+	}
+	if err := rl.Close(); err != nil {
+		log.Printf("Failed to flush limiter state: %v", err)
+	}
+	if err := quotaService.Close(); err != nil {
+		log.Printf("Failed to flush quota usage: %v", err)
+	}
+	leases.Close()
+	if err := db.Close(); err != nil {
+		log.Printf("Failed to close DB: %v", err)
 	}
 
 	log.Println("Server stopped.")